@@ -1,17 +1,24 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/Arturstriker3/api-go/config"
+	"github.com/Arturstriker3/api-go/internal/auth"
 	"github.com/Arturstriker3/api-go/internal/email"
+	"github.com/Arturstriker3/api-go/internal/httpapi"
+	"github.com/Arturstriker3/api-go/internal/inbound"
 	"github.com/Arturstriker3/api-go/internal/queue"
+	"github.com/Arturstriker3/api-go/internal/smtpd"
 	"github.com/Arturstriker3/api-go/internal/tcp"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -41,7 +48,6 @@ func main() {
 	if err != nil {
 		log.Fatalf("🔴 Failed to create consumer: %v", err)
 	}
-	defer consumer.Close()
 
 	if err := consumer.Setup(); err != nil {
 		log.Fatalf("🔴 Failed to setup consumer: %v", err)
@@ -51,47 +57,180 @@ func main() {
 		log.Fatalf("🔴 Failed to start consuming: %v", err)
 	}
 
+	// Load API keys
+	keyStore, err := auth.LoadKeyStore(cfg.Auth.KeysFile, cfg.TCP.AuthSecret)
+	if err != nil {
+		log.Fatalf("🔴 Failed to load API keys: %v", err)
+	}
+	keyStore.StartWatcher()
+
+	// Brute-force protection shared by every listener
+	guard, err := auth.NewGuard(cfg.Auth)
+	if err != nil {
+		log.Fatalf("🔴 Failed to configure auth guard: %v", err)
+	}
+	guard.StartCleanup()
+
 	// Initialize TCP server
-	tcpServer, err := tcp.NewServer(cfg, emailService)
+	handler := tcp.NewHandler(cfg, emailService, keyStore, guard)
+	tcpServer, err := tcp.NewServer(cfg, handler)
 	if err != nil {
 		log.Fatalf("🔴 Failed to create TCP server: %v", err)
 	}
 
+	// Initialize HTTP API, sharing the TCP server's handler and TLS config
+	var httpServer *httpapi.Server
+	if cfg.HTTP.Enabled {
+		var tlsConfig *tls.Config
+		if cfg.HTTP.TLS {
+			if tlsConfig, err = tcpServer.TLSConfig(); err != nil {
+				log.Fatalf("🔴 Failed to load TLS configuration for the HTTP API: %v", err)
+			}
+		}
+		httpServer = httpapi.NewServer(cfg, handler, tlsConfig)
+	}
+
+	// Initialize SMTP submission listener; STARTTLS uses the server certificate
+	var smtpServer *smtpd.Server
+	if cfg.SMTPD.Enabled {
+		tlsConfig, err := tcpServer.TLSConfig()
+		if err != nil {
+			if cfg.SMTPD.RequireTLS {
+				log.Fatalf("🔴 SMTP submission requires TLS but the certificate could not be loaded: %v", err)
+			}
+			log.Printf("🟡 STARTTLS disabled for SMTP submission: %v", err)
+			tlsConfig = nil
+		}
+		smtpServer = smtpd.NewServer(cfg, cfg.SMTPD, handler, smtpd.NewSubmissionBackend(handler), tlsConfig)
+	}
+
+	// Initialize inbound listener; STARTTLS is opportunistic between MTAs
+	var inboundServer *smtpd.Server
+	var inboundBackend *inbound.Backend
+	if cfg.Inbound.Listener.Enabled {
+		inboundBackend, err = inbound.NewBackend(cfg)
+		if err != nil {
+			log.Fatalf("🔴 Failed to configure inbound forwarding: %v", err)
+		}
+		tlsConfig, err := tcpServer.TLSConfig()
+		if err != nil {
+			log.Printf("🟡 STARTTLS disabled for inbound SMTP: %v", err)
+			tlsConfig = nil
+		}
+		inboundServer = smtpd.NewServer(cfg, cfg.Inbound.Listener, handler, inboundBackend, tlsConfig)
+	}
+
 	// Start certificate notification watcher
 	go startCertificateNotificationWatcher(certEmailService)
 
 	// Start metrics server in a separate goroutine
+	metricsPort := cfg.Metrics.Port
+	if metricsPort == "" {
+		metricsPort = "9091" // Default metrics port
+	}
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", promhttp.Handler())
+	metricsServer := &http.Server{Addr: ":" + metricsPort, Handler: metricsMux}
 	go func() {
-		metricsPort := cfg.Metrics.Port
-		if metricsPort == "" {
-			metricsPort = "9091" // Default metrics port
-		}
 		log.Printf("🟡 Starting metrics server on port %s", metricsPort)
-		http.Handle("/metrics", promhttp.Handler())
-		if err := http.ListenAndServe(":"+metricsPort, nil); err != nil {
+		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Printf("🔴 Metrics server error: %v", err)
 		}
 	}()
 
-	// Start TCP server
-	go func() {
-		log.Printf("🟢 Starting TCP server on port %s", cfg.TCP.Port)
-		if err := tcpServer.Start(); err != nil {
-			log.Fatalf("🔴 Failed to start TCP server: %v", err)
-		}
-	}()
+	// Start TCP server, unless only the HTTP API or SMTP listeners are wanted
+	if len(cfg.TCP.Listeners) > 0 {
+		go func() {
+			log.Printf("🟢 Starting TCP server with %d listener(s)", len(cfg.TCP.Listeners))
+			if err := tcpServer.Start(); err != nil {
+				log.Fatalf("🔴 Failed to start TCP server: %v", err)
+			}
+		}()
+	} else {
+		log.Println("🟡 No TCP listener configured, TCP server not started")
+	}
+
+	// Start HTTP API
+	if httpServer != nil {
+		go func() {
+			if err := httpServer.Start(); err != nil {
+				log.Fatalf("🔴 Failed to start HTTP API: %v", err)
+			}
+		}()
+	}
+
+	// Start SMTP submission listener
+	if smtpServer != nil {
+		go func() {
+			if err := smtpServer.Start(); err != nil {
+				log.Fatalf("🔴 Failed to start SMTP submission listener: %v", err)
+			}
+		}()
+	}
+
+	// Start inbound listener
+	if inboundServer != nil {
+		go func() {
+			if err := inboundServer.Start(); err != nil {
+				log.Fatalf("🔴 Failed to start inbound SMTP listener: %v", err)
+			}
+		}()
+	}
 
 	// Wait for interrupt signal to gracefully shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("🟡 Shutting down servers...")
-	
-	// Graceful shutdown
-	if err := tcpServer.Stop(); err != nil {
-		log.Printf("🔴 Error stopping TCP server: %v", err)
+	log.Printf("🟡 Shutting down (timeout %s)...", cfg.Shutdown.Timeout)
+
+	// Graceful shutdown, in order: stop taking requests on every listener at
+	// once and let the requests in flight reach the queue, stop consuming
+	// once the current email is acked, then close the RabbitMQ connections.
+	// The consumer gets its own reserved share of the timeout so slow
+	// connections cannot leave the email being sent without time to be acked.
+	listenersCtx, cancelListeners := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout-cfg.Shutdown.ConsumerTimeout)
+	defer cancelListeners()
+
+	var drained sync.WaitGroup
+	drain := func(name string, shutdown func(context.Context) error) {
+		drained.Add(1)
+		go func() {
+			defer drained.Done()
+			if err := shutdown(listenersCtx); err != nil {
+				log.Printf("🔴 Error stopping %s: %v", name, err)
+			}
+		}()
+	}
+	if len(cfg.TCP.Listeners) > 0 {
+		drain("TCP server", tcpServer.Shutdown)
+	}
+	if httpServer != nil {
+		drain("HTTP API", httpServer.Shutdown)
+	}
+	if smtpServer != nil {
+		drain("SMTP submission listener", smtpServer.Shutdown)
+	}
+	if inboundServer != nil {
+		drain("inbound SMTP listener", inboundServer.Shutdown)
+	}
+	drained.Wait()
+	if inboundBackend != nil {
+		inboundBackend.Close()
+	}
+
+	consumerCtx, cancelConsumer := context.WithTimeout(context.Background(), cfg.Shutdown.ConsumerTimeout)
+	defer cancelConsumer()
+	if err := consumer.Shutdown(consumerCtx); err != nil {
+		log.Printf("🔴 Error stopping consumer: %v", err)
+	}
+	consumer.Close()
+	emailService.Close()
+
+	if err := metricsServer.Shutdown(consumerCtx); err != nil {
+		log.Printf("🔴 Error stopping metrics server: %v", err)
 	}
+	log.Println("✅ Shutdown complete")
 }
 
 // startCertificateNotificationWatcher monitors for certificate notifications and sends emails
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -11,8 +13,20 @@ import (
 type Config struct {
 	RabbitMQ RabbitMQConfig
 	SMTP     SMTPConfig
+	Email    EmailConfig
 	TCP      TCPConfig
 	Metrics  MetricsConfig
+	Status   StatusConfig
+	Auth     AuthConfig
+	Shutdown ShutdownConfig
+	HTTP     HTTPConfig
+	SMTPD    SMTPDConfig
+	Inbound  InboundConfig
+
+	Idempotency IdempotencyConfig
+	Dedupe      DedupeConfig
+
+	Backpressure BackpressureConfig
 }
 
 type RabbitMQConfig struct {
@@ -30,11 +44,76 @@ type SMTPConfig struct {
 	From     string
 }
 
+// EmailConfig limits the emails clients may queue
+type EmailConfig struct {
+	// MaxRecipients bounds to, cc and bcc combined; 0 means unlimited
+	MaxRecipients int
+	// MaxAttachmentSize bounds each decoded attachment and
+	// MaxAttachmentsSize all of them together, in bytes
+	MaxAttachmentSize  int
+	MaxAttachmentsSize int
+	// AttachmentTypes are the lowercased content types attachments may have
+	AttachmentTypes []string
+}
+
 type TCPConfig struct {
-	Port       string
-	AuthSecret string
-	Enabled    bool
-	TLS        TLSConfig
+	Port         string
+	AuthSecret   string
+	Enabled      bool
+	MaxFrameSize int
+	// MaxPreAuthFrameSize bounds frames until the session authenticates, so
+	// clients without credentials cannot make the server buffer large frames
+	MaxPreAuthFrameSize int
+	// MinProtocolVersion lets operators retire older clients once every
+	// deployment speaks a newer version
+	MinProtocolVersion int
+	MaxBatchSize       int
+	// RequireHMACAuth rejects plaintext secrets on unencrypted connections,
+	// forcing clients to use the challenge-response handshake
+	RequireHMACAuth bool
+	TLS             TLSConfig
+	// Connection limits; 0 means unlimited
+	MaxConnections      int
+	MaxConnectionsPerIP int
+	// HandshakeTimeout bounds the TLS handshake, AuthTimeout the time from
+	// connecting to authenticating, IdleTimeout the wait for each request and
+	// WriteTimeout the time a client may take to read a response
+	HandshakeTimeout time.Duration
+	AuthTimeout      time.Duration
+	IdleTimeout      time.Duration
+	WriteTimeout     time.Duration
+	// Listeners are the sockets the server accepts connections on, from
+	// TCP_LISTENERS or derived from the legacy TCP_PORT/TCP_PLAIN_PORT settings
+	Listeners []ListenerConfig
+	// ProxyProtocol expects a HAProxy PROXY protocol v1 or v2 header from
+	// clients in ProxyTrustedCIDRs (comma-separated), such as a load
+	// balancer; other clients connect directly
+	ProxyProtocol     bool
+	ProxyTrustedCIDRs string
+	// UnixSocketMode is the file mode of Unix socket listeners; callers are
+	// identified by their uid/gid, so only local users allowed by the mode
+	// can connect
+	UnixSocketMode os.FileMode
+}
+
+// ListenerConfig is one address the TCP server listens on. Every listener
+// shares the same handler; TLS listeners use the TCP_TLS_* settings and Unix
+// listeners take a socket path as Address.
+type ListenerConfig struct {
+	Address string
+	TLS     bool
+	Unix    bool
+}
+
+// String formats the listener the way it is written in TCP_LISTENERS
+func (l ListenerConfig) String() string {
+	switch {
+	case l.TLS:
+		return "tls://" + l.Address
+	case l.Unix:
+		return "unix://" + l.Address
+	}
+	return "tcp://" + l.Address
 }
 
 type TLSConfig struct {
@@ -42,12 +121,140 @@ type TLSConfig struct {
 	CertPath string
 	KeyPath  string
 	CAPath   string
+	// ClientAuth is "none", "optional" or "required"; client certificates
+	// are verified against CAPath
+	ClientAuth string
+	// ClientCertMode is "identity" when a mapped certificate authenticates
+	// the session on its own, or "secret" when a secret is still required
+	ClientCertMode string
+	CRLPath        string
 }
 
 type MetricsConfig struct {
 	Port string
 }
 
+type AuthConfig struct {
+	// KeysFile is a JSON file with named API keys; TCP_AUTH_SECRET is
+	// still accepted as the "default" key
+	KeysFile string
+
+	// Brute-force protection: MaxFailures failed logins from one IP within
+	// FailureWindow ban it for BanDuration, doubling on every repeat offence
+	// up to MaxBanDuration. MaxFailures 0 disables bans.
+	MaxFailures    int
+	FailureWindow  time.Duration
+	BanDuration    time.Duration
+	MaxBanDuration time.Duration
+	// Allowlist and Denylist are comma-separated CIDRs; allowlisted clients
+	// are never banned and denylisted clients are always refused
+	Allowlist string
+	Denylist  string
+}
+
+type StatusConfig struct {
+	// Retention is how long delivery statuses stay queryable after their
+	// last update
+	Retention time.Duration
+}
+
+type IdempotencyConfig struct {
+	// Window is how long an idempotency key is remembered after the email
+	// it queued; 0 disables deduplication
+	Window time.Duration
+}
+
+// DedupeConfig configures the consumer's record of sent messages, used to
+// skip emails RabbitMQ redelivers after they were sent but before the ack
+// reached the broker
+type DedupeConfig struct {
+	// Path is the append-only file the message IDs are written to; empty
+	// disables deduplication
+	Path string
+	// Retention is how long a sent message ID is remembered
+	Retention time.Duration
+}
+
+// BackpressureConfig slows down and then refuses new emails while the queue
+// backlog is deep, so an incident flood does not exhaust the SMTP quota.
+// Between SoftLimit and HardLimit queued messages each send is delayed by up
+// to MaxDelay, growing with the backlog; from HardLimit on sends are refused
+// with a busy error asking clients to retry after RetryAfter. A limit of 0
+// disables that stage.
+type BackpressureConfig struct {
+	SoftLimit  int
+	HardLimit  int
+	MaxDelay   time.Duration
+	RetryAfter time.Duration
+}
+
+type HTTPConfig struct {
+	Enabled bool
+	Address string
+	// TLS serves HTTPS with the TCP_TLS_* certificate and client
+	// certificate settings
+	TLS bool
+	// ReadTimeout bounds reading a whole request, body included, so slow
+	// uploads cannot hold a handler indefinitely
+	ReadTimeout time.Duration
+	// MaxConnections and MaxConnectionsPerIP cap open connections, in total
+	// and from one client IP; 0 means unlimited
+	MaxConnections      int
+	MaxConnectionsPerIP int
+}
+
+// SMTPDConfig configures the SMTP submission listener for clients that can
+// only relay through SMTP. Not to be confused with SMTPConfig, the upstream
+// server emails are delivered through.
+type SMTPDConfig struct {
+	Enabled  bool
+	Address  string
+	Hostname string
+	// RequireTLS only offers AUTH after STARTTLS
+	RequireTLS     bool
+	MaxMessageSize int
+	MaxRecipients  int
+	// Connection limits; 0 means unlimited
+	MaxConnections      int
+	MaxConnectionsPerIP int
+}
+
+// InboundConfig configures the receive-only SMTP listener. Mail addressed to
+// Domains is parsed into an event and forwarded to the webhook, the RabbitMQ
+// exchange or both; nothing received here is ever relayed.
+type InboundConfig struct {
+	// Listener reuses the submission settings; RequireTLS is ignored since
+	// inbound mail is never authenticated
+	Listener SMTPDConfig
+	Domains  []string
+	// WebhookSecret signs every webhook request with HMAC-SHA256
+	WebhookURL     string
+	WebhookSecret  string
+	WebhookRetries int
+	WebhookTimeout time.Duration
+	// Exchange is a topic exchange events are published to with the
+	// routing key "inbound.email"
+	Exchange string
+}
+
+type ShutdownConfig struct {
+	// Timeout bounds how long connections and the email being sent may take
+	// to finish once a shutdown signal is received
+	Timeout time.Duration
+	// ConsumerTimeout is the part of Timeout reserved for the email being
+	// sent, so slow connections cannot use it up
+	ConsumerTimeout time.Duration
+}
+
+// defaultAttachmentTypes covers documents, spreadsheets and images; archives
+// and executables are left out since they can hide malware
+const defaultAttachmentTypes = "application/pdf,text/plain,text/csv,text/calendar," +
+	"image/png,image/jpeg,image/gif,image/webp," +
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document," +
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet," +
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation," +
+	"application/vnd.oasis.opendocument.text,application/vnd.oasis.opendocument.spreadsheet"
+
 // LoadConfig loads the configuration from environment variables
 func LoadConfig() (*Config, error) {
 	// Load .env file if it exists
@@ -59,6 +266,223 @@ func LoadConfig() (*Config, error) {
 		return nil, fmt.Errorf("invalid SMTP_PORT: %w", err)
 	}
 
+	// Email Configuration
+	emailMaxRecipients, err := strconv.Atoi(getEnvWithDefault("EMAIL_MAX_RECIPIENTS", "100"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid EMAIL_MAX_RECIPIENTS: %w", err)
+	}
+
+	emailMaxAttachmentSize, err := strconv.Atoi(getEnvWithDefault("EMAIL_MAX_ATTACHMENT_SIZE", "5242880"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid EMAIL_MAX_ATTACHMENT_SIZE: %w", err)
+	}
+
+	emailMaxAttachmentsSize, err := strconv.Atoi(getEnvWithDefault("EMAIL_MAX_ATTACHMENTS_SIZE", "7340032"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid EMAIL_MAX_ATTACHMENTS_SIZE: %w", err)
+	}
+
+	// TCP Configuration
+	maxFrameSize, err := strconv.Atoi(getEnvWithDefault("TCP_MAX_FRAME_SIZE", "10485760"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TCP_MAX_FRAME_SIZE: %w", err)
+	}
+
+	maxPreAuthFrameSize, err := strconv.Atoi(getEnvWithDefault("TCP_MAX_PREAUTH_FRAME_SIZE", "16384"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TCP_MAX_PREAUTH_FRAME_SIZE: %w", err)
+	}
+
+	minProtocolVersion, err := strconv.Atoi(getEnvWithDefault("TCP_MIN_PROTOCOL_VERSION", "1"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TCP_MIN_PROTOCOL_VERSION: %w", err)
+	}
+
+	maxBatchSize, err := strconv.Atoi(getEnvWithDefault("TCP_MAX_BATCH_SIZE", "1000"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TCP_MAX_BATCH_SIZE: %w", err)
+	}
+
+	maxConnections, err := strconv.Atoi(getEnvWithDefault("TCP_MAX_CONNECTIONS", "1000"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TCP_MAX_CONNECTIONS: %w", err)
+	}
+
+	maxConnectionsPerIP, err := strconv.Atoi(getEnvWithDefault("TCP_MAX_CONNECTIONS_PER_IP", "50"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TCP_MAX_CONNECTIONS_PER_IP: %w", err)
+	}
+
+	handshakeTimeout, err := time.ParseDuration(getEnvWithDefault("TCP_HANDSHAKE_TIMEOUT", "10s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TCP_HANDSHAKE_TIMEOUT: %w", err)
+	}
+
+	authTimeout, err := time.ParseDuration(getEnvWithDefault("TCP_AUTH_TIMEOUT", "30s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TCP_AUTH_TIMEOUT: %w", err)
+	}
+
+	idleTimeout, err := time.ParseDuration(getEnvWithDefault("TCP_IDLE_TIMEOUT", "5m"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TCP_IDLE_TIMEOUT: %w", err)
+	}
+
+	writeTimeout, err := time.ParseDuration(getEnvWithDefault("TCP_WRITE_TIMEOUT", "30s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TCP_WRITE_TIMEOUT: %w", err)
+	}
+
+	// Status Configuration
+	statusRetention, err := time.ParseDuration(getEnvWithDefault("STATUS_RETENTION", "24h"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid STATUS_RETENTION: %w", err)
+	}
+
+	idempotencyWindow, err := time.ParseDuration(getEnvWithDefault("IDEMPOTENCY_WINDOW", "24h"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid IDEMPOTENCY_WINDOW: %w", err)
+	}
+
+	dedupeRetention, err := time.ParseDuration(getEnvWithDefault("DEDUPE_RETENTION", "168h"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DEDUPE_RETENTION: %w", err)
+	}
+
+	// Backpressure Configuration
+	backpressureSoftLimit, err := strconv.Atoi(getEnvWithDefault("BACKPRESSURE_SOFT_LIMIT", "0"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid BACKPRESSURE_SOFT_LIMIT: %w", err)
+	}
+
+	backpressureHardLimit, err := strconv.Atoi(getEnvWithDefault("BACKPRESSURE_HARD_LIMIT", "0"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid BACKPRESSURE_HARD_LIMIT: %w", err)
+	}
+
+	backpressureMaxDelay, err := time.ParseDuration(getEnvWithDefault("BACKPRESSURE_MAX_DELAY", "2s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid BACKPRESSURE_MAX_DELAY: %w", err)
+	}
+
+	backpressureRetryAfter, err := time.ParseDuration(getEnvWithDefault("BACKPRESSURE_RETRY_AFTER", "30s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid BACKPRESSURE_RETRY_AFTER: %w", err)
+	}
+
+	// SMTP submission listener Configuration
+	smtpdMaxMessageSize, err := strconv.Atoi(getEnvWithDefault("SMTPD_MAX_MESSAGE_SIZE", "10485760"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTPD_MAX_MESSAGE_SIZE: %w", err)
+	}
+
+	smtpdMaxRecipients, err := strconv.Atoi(getEnvWithDefault("SMTPD_MAX_RECIPIENTS", "100"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTPD_MAX_RECIPIENTS: %w", err)
+	}
+
+	// Inbound Configuration
+	smtpdMaxConnections, err := strconv.Atoi(getEnvWithDefault("SMTPD_MAX_CONNECTIONS", "100"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTPD_MAX_CONNECTIONS: %w", err)
+	}
+
+	smtpdMaxConnectionsPerIP, err := strconv.Atoi(getEnvWithDefault("SMTPD_MAX_CONNECTIONS_PER_IP", "10"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTPD_MAX_CONNECTIONS_PER_IP: %w", err)
+	}
+
+	inboundMaxMessageSize, err := strconv.Atoi(getEnvWithDefault("INBOUND_MAX_MESSAGE_SIZE", "26214400"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid INBOUND_MAX_MESSAGE_SIZE: %w", err)
+	}
+
+	inboundMaxRecipients, err := strconv.Atoi(getEnvWithDefault("INBOUND_MAX_RECIPIENTS", "100"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid INBOUND_MAX_RECIPIENTS: %w", err)
+	}
+
+	inboundMaxConnections, err := strconv.Atoi(getEnvWithDefault("INBOUND_MAX_CONNECTIONS", "100"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid INBOUND_MAX_CONNECTIONS: %w", err)
+	}
+
+	inboundMaxConnectionsPerIP, err := strconv.Atoi(getEnvWithDefault("INBOUND_MAX_CONNECTIONS_PER_IP", "5"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid INBOUND_MAX_CONNECTIONS_PER_IP: %w", err)
+	}
+
+	inboundWebhookRetries, err := strconv.Atoi(getEnvWithDefault("INBOUND_WEBHOOK_RETRIES", "5"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid INBOUND_WEBHOOK_RETRIES: %w", err)
+	}
+
+	inboundWebhookTimeout, err := time.ParseDuration(getEnvWithDefault("INBOUND_WEBHOOK_TIMEOUT", "10s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid INBOUND_WEBHOOK_TIMEOUT: %w", err)
+	}
+
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "localhost"
+	}
+
+	shutdownTimeout, err := time.ParseDuration(getEnvWithDefault("SHUTDOWN_TIMEOUT", "30s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHUTDOWN_TIMEOUT: %w", err)
+	}
+
+	shutdownConsumerTimeout, err := time.ParseDuration(getEnvWithDefault("SHUTDOWN_CONSUMER_TIMEOUT", "10s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SHUTDOWN_CONSUMER_TIMEOUT: %w", err)
+	}
+
+	httpReadTimeout, err := time.ParseDuration(getEnvWithDefault("HTTP_READ_TIMEOUT", "60s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP_READ_TIMEOUT: %w", err)
+	}
+
+	httpMaxConnections, err := strconv.Atoi(getEnvWithDefault("HTTP_MAX_CONNECTIONS", "100"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP_MAX_CONNECTIONS: %w", err)
+	}
+
+	httpMaxConnectionsPerIP, err := strconv.Atoi(getEnvWithDefault("HTTP_MAX_CONNECTIONS_PER_IP", "10"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP_MAX_CONNECTIONS_PER_IP: %w", err)
+	}
+
+	listeners, err := parseListeners(os.Getenv("TCP_LISTENERS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TCP_LISTENERS: %w", err)
+	}
+
+	unixSocketMode, err := strconv.ParseUint(getEnvWithDefault("TCP_UNIX_SOCKET_MODE", "0660"), 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TCP_UNIX_SOCKET_MODE: %w", err)
+	}
+
+	// Auth Configuration
+	authMaxFailures, err := strconv.Atoi(getEnvWithDefault("AUTH_MAX_FAILURES", "5"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid AUTH_MAX_FAILURES: %w", err)
+	}
+
+	authFailureWindow, err := time.ParseDuration(getEnvWithDefault("AUTH_FAILURE_WINDOW", "10m"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid AUTH_FAILURE_WINDOW: %w", err)
+	}
+
+	authBanDuration, err := time.ParseDuration(getEnvWithDefault("AUTH_BAN_DURATION", "1m"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid AUTH_BAN_DURATION: %w", err)
+	}
+
+	authMaxBanDuration, err := time.ParseDuration(getEnvWithDefault("AUTH_MAX_BAN_DURATION", "24h"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid AUTH_MAX_BAN_DURATION: %w", err)
+	}
+
 	config := &Config{
 		SMTP: SMTPConfig{
 			Host:     getEnvWithDefault("SMTP_HOST", "smtp.gmail.com"),
@@ -67,6 +491,12 @@ func LoadConfig() (*Config, error) {
 			Password: os.Getenv("SMTP_PASSWORD"),
 			From:     os.Getenv("SMTP_FROM"),
 		},
+		Email: EmailConfig{
+			MaxRecipients:      emailMaxRecipients,
+			MaxAttachmentSize:  emailMaxAttachmentSize,
+			MaxAttachmentsSize: emailMaxAttachmentsSize,
+			AttachmentTypes:    splitList(getEnvWithDefault("EMAIL_ATTACHMENT_TYPES", defaultAttachmentTypes)),
+		},
 		RabbitMQ: RabbitMQConfig{
 			Host:     getEnvWithDefault("RABBITMQ_HOST", "localhost"),
 			Port:     getEnvWithDefault("RABBITMQ_PORT", "5672"),
@@ -74,19 +504,114 @@ func LoadConfig() (*Config, error) {
 			Password: getEnvWithDefault("RABBITMQ_PASSWORD", "admin"),
 		},
 		TCP: TCPConfig{
-			Port:       getEnvWithDefault("TCP_PORT", "9000"),
-			AuthSecret: os.Getenv("TCP_AUTH_SECRET"),
-			Enabled:    getEnvWithDefault("TCP_ENABLED", "true") == "true",
+			Port:                getEnvWithDefault("TCP_PORT", "9000"),
+			AuthSecret:          os.Getenv("TCP_AUTH_SECRET"),
+			Enabled:             getEnvWithDefault("TCP_ENABLED", "true") == "true",
+			MaxFrameSize:        maxFrameSize,
+			MaxPreAuthFrameSize: maxPreAuthFrameSize,
+			MinProtocolVersion:  minProtocolVersion,
+			MaxBatchSize:        maxBatchSize,
+			RequireHMACAuth:     getEnvWithDefault("TCP_AUTH_REQUIRE_HMAC", "false") == "true",
+
+			MaxConnections:      maxConnections,
+			MaxConnectionsPerIP: maxConnectionsPerIP,
+			HandshakeTimeout:    handshakeTimeout,
+			AuthTimeout:         authTimeout,
+			IdleTimeout:         idleTimeout,
+			WriteTimeout:        writeTimeout,
+
 			TLS: TLSConfig{
 				Enabled:  getEnvWithDefault("TCP_TLS_ENABLED", "false") == "true",
 				CertPath: getEnvWithDefault("TCP_TLS_CERT_PATH", "certs/server.crt"),
 				KeyPath:  getEnvWithDefault("TCP_TLS_KEY_PATH", "certs/server.key"),
 				CAPath:   getEnvWithDefault("TCP_TLS_CA_PATH", "certs/ca-cert.pem"),
+
+				ClientAuth:     getEnvWithDefault("TCP_TLS_CLIENT_AUTH", "none"),
+				ClientCertMode: getEnvWithDefault("TCP_TLS_CLIENT_CERT_MODE", "identity"),
+				CRLPath:        os.Getenv("TCP_TLS_CRL_PATH"),
 			},
+			Listeners: listeners,
+
+			ProxyProtocol:     getEnvWithDefault("TCP_PROXY_PROTOCOL", "false") == "true",
+			ProxyTrustedCIDRs: os.Getenv("TCP_PROXY_TRUSTED_CIDRS"),
+			UnixSocketMode:    os.FileMode(unixSocketMode),
 		},
 		Metrics: MetricsConfig{
 			Port: getEnvWithDefault("METRICS_PORT", "9091"),
 		},
+		Status: StatusConfig{
+			Retention: statusRetention,
+		},
+		Idempotency: IdempotencyConfig{
+			Window: idempotencyWindow,
+		},
+		Dedupe: DedupeConfig{
+			Path:      getEnvWithDefault("DEDUPE_PATH", "data/sent.log"),
+			Retention: dedupeRetention,
+		},
+		Backpressure: BackpressureConfig{
+			SoftLimit:  backpressureSoftLimit,
+			HardLimit:  backpressureHardLimit,
+			MaxDelay:   backpressureMaxDelay,
+			RetryAfter: backpressureRetryAfter,
+		},
+		Auth: AuthConfig{
+			KeysFile: os.Getenv("AUTH_KEYS_FILE"),
+
+			MaxFailures:    authMaxFailures,
+			FailureWindow:  authFailureWindow,
+			BanDuration:    authBanDuration,
+			MaxBanDuration: authMaxBanDuration,
+			Allowlist:      os.Getenv("AUTH_ALLOWLIST"),
+			Denylist:       os.Getenv("AUTH_DENYLIST"),
+		},
+		Shutdown: ShutdownConfig{
+			Timeout:         shutdownTimeout,
+			ConsumerTimeout: shutdownConsumerTimeout,
+		},
+		SMTPD: SMTPDConfig{
+			Enabled:        getEnvWithDefault("SMTPD_ENABLED", "false") == "true",
+			Address:        getEnvWithDefault("SMTPD_ADDR", ":2525"),
+			Hostname:       getEnvWithDefault("SMTPD_HOSTNAME", hostname),
+			RequireTLS:     getEnvWithDefault("SMTPD_REQUIRE_TLS", "true") == "true",
+			MaxMessageSize: smtpdMaxMessageSize,
+			MaxRecipients:  smtpdMaxRecipients,
+
+			MaxConnections:      smtpdMaxConnections,
+			MaxConnectionsPerIP: smtpdMaxConnectionsPerIP,
+		},
+		Inbound: InboundConfig{
+			Listener: SMTPDConfig{
+				Enabled:        getEnvWithDefault("INBOUND_ENABLED", "false") == "true",
+				Address:        getEnvWithDefault("INBOUND_ADDR", ":25"),
+				Hostname:       getEnvWithDefault("INBOUND_HOSTNAME", hostname),
+				MaxMessageSize: inboundMaxMessageSize,
+				MaxRecipients:  inboundMaxRecipients,
+
+				MaxConnections:      inboundMaxConnections,
+				MaxConnectionsPerIP: inboundMaxConnectionsPerIP,
+			},
+			Domains:        splitList(os.Getenv("INBOUND_DOMAINS")),
+			WebhookURL:     os.Getenv("INBOUND_WEBHOOK_URL"),
+			WebhookSecret:  os.Getenv("INBOUND_WEBHOOK_SECRET"),
+			WebhookRetries: inboundWebhookRetries,
+			WebhookTimeout: inboundWebhookTimeout,
+			Exchange:       os.Getenv("INBOUND_EXCHANGE"),
+		},
+		HTTP: HTTPConfig{
+			Enabled: getEnvWithDefault("HTTP_ENABLED", "false") == "true",
+			Address: getEnvWithDefault("HTTP_ADDR", ":8080"),
+			TLS:     getEnvWithDefault("HTTP_TLS_ENABLED", "false") == "true",
+
+			ReadTimeout: httpReadTimeout,
+
+			MaxConnections:      httpMaxConnections,
+			MaxConnectionsPerIP: httpMaxConnectionsPerIP,
+		},
+	}
+
+	if len(config.TCP.Listeners) == 0 {
+		config.TCP.Listeners = legacyListeners(config.TCP, os.Getenv("TCP_PLAIN_PORT"))
 	}
 
 	// Validate required environment variables
@@ -112,22 +637,155 @@ func (c *Config) validate() error {
 		missingVars = append(missingVars, "SMTP_FROM")
 	}
 
-	// Check required TCP variables
-	if c.TCP.AuthSecret == "" {
-		missingVars = append(missingVars, "TCP_AUTH_SECRET")
+	// Check required TCP variables (a keys file can replace the shared secret)
+	if c.TCP.AuthSecret == "" && c.Auth.KeysFile == "" {
+		missingVars = append(missingVars, "TCP_AUTH_SECRET or AUTH_KEYS_FILE")
 	}
 
 	if len(missingVars) > 0 {
 		return fmt.Errorf("missing required environment variables: %v", missingVars)
 	}
 
+	switch c.TCP.TLS.ClientAuth {
+	case "none", "optional", "required":
+	default:
+		return fmt.Errorf("invalid TCP_TLS_CLIENT_AUTH %q (use none, optional or required)", c.TCP.TLS.ClientAuth)
+	}
+	switch c.TCP.TLS.ClientCertMode {
+	case "identity", "secret":
+	default:
+		return fmt.Errorf("invalid TCP_TLS_CLIENT_CERT_MODE %q (use identity or secret)", c.TCP.TLS.ClientCertMode)
+	}
+
+	if c.Auth.MaxFailures > 0 && (c.Auth.BanDuration <= 0 || c.Auth.MaxBanDuration < c.Auth.BanDuration) {
+		return fmt.Errorf("AUTH_BAN_DURATION must be positive and not exceed AUTH_MAX_BAN_DURATION")
+	}
+
+	if c.Shutdown.ConsumerTimeout <= 0 || c.Shutdown.ConsumerTimeout >= c.Shutdown.Timeout {
+		return fmt.Errorf("SHUTDOWN_CONSUMER_TIMEOUT must be positive and shorter than SHUTDOWN_TIMEOUT")
+	}
+
+	// A deployment may serve only the HTTP API or SMTP, but needs a way in
+	if len(c.TCP.Listeners) == 0 && !c.HTTP.Enabled && !c.SMTPD.Enabled && !c.Inbound.Listener.Enabled {
+		return fmt.Errorf("no listener enabled: set TCP_ENABLED, TCP_TLS_ENABLED, TCP_LISTENERS, HTTP_ENABLED, SMTPD_ENABLED or INBOUND_ENABLED")
+	}
+
+	if c.HTTP.ReadTimeout <= 0 {
+		return fmt.Errorf("HTTP_READ_TIMEOUT must be positive")
+	}
+
+	// Length prefixes of 16 MiB or more start with a non-zero byte, which
+	// framing detection would take for the start of a JSON message
+	if c.TCP.MaxFrameSize >= 1<<24 {
+		return fmt.Errorf("TCP_MAX_FRAME_SIZE must be below 16777216 (16 MiB)")
+	}
+
+	if c.TCP.MaxPreAuthFrameSize <= 0 {
+		return fmt.Errorf("TCP_MAX_PREAUTH_FRAME_SIZE must be positive")
+	}
+
+	if c.TCP.ProxyProtocol && strings.TrimSpace(c.TCP.ProxyTrustedCIDRs) == "" {
+		return fmt.Errorf("TCP_PROXY_TRUSTED_CIDRS is required when TCP_PROXY_PROTOCOL is true")
+	}
+
+	if c.Dedupe.Path != "" && c.Dedupe.Retention <= 0 {
+		return fmt.Errorf("DEDUPE_RETENTION must be positive when DEDUPE_PATH is set")
+	}
+
+	if c.Backpressure.SoftLimit < 0 || c.Backpressure.HardLimit < 0 {
+		return fmt.Errorf("BACKPRESSURE_SOFT_LIMIT and BACKPRESSURE_HARD_LIMIT must not be negative")
+	}
+	if c.Backpressure.SoftLimit > 0 && c.Backpressure.SoftLimit >= c.Backpressure.HardLimit {
+		return fmt.Errorf("BACKPRESSURE_SOFT_LIMIT requires a greater BACKPRESSURE_HARD_LIMIT")
+	}
+	if c.Backpressure.HardLimit > 0 && c.Backpressure.RetryAfter < time.Second {
+		return fmt.Errorf("BACKPRESSURE_RETRY_AFTER must be at least 1s")
+	}
+
+	if c.Inbound.Listener.Enabled {
+		if len(c.Inbound.Domains) == 0 {
+			return fmt.Errorf("INBOUND_DOMAINS is required when INBOUND_ENABLED is true")
+		}
+		if c.Inbound.WebhookURL == "" && c.Inbound.Exchange == "" {
+			return fmt.Errorf("INBOUND_WEBHOOK_URL or INBOUND_EXCHANGE is required when INBOUND_ENABLED is true")
+		}
+		if c.Inbound.WebhookURL != "" && c.Inbound.WebhookSecret == "" {
+			return fmt.Errorf("INBOUND_WEBHOOK_SECRET is required with INBOUND_WEBHOOK_URL")
+		}
+	}
+
 	return nil
 }
 
+// parseListeners reads a comma-separated list such as
+// "tls://0.0.0.0:9000,tcp://127.0.0.1:9001"
+func parseListeners(spec string) ([]ListenerConfig, error) {
+	var listeners []ListenerConfig
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		scheme, address, found := strings.Cut(entry, "://")
+		if !found || address == "" {
+			return nil, fmt.Errorf("%q must look like tcp://host:port, tls://host:port or unix:///path", entry)
+		}
+
+		var listener ListenerConfig
+		switch scheme {
+		case "tcp":
+		case "tls":
+			listener.TLS = true
+		case "unix":
+			listener.Unix = true
+		default:
+			return nil, fmt.Errorf("unknown scheme %q in %q (use tcp, tls or unix)", scheme, entry)
+		}
+
+		if !listener.Unix && !strings.Contains(address, ":") {
+			return nil, fmt.Errorf("%q is missing a port", entry)
+		}
+		listener.Address = address
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// legacyListeners maps TCP_ENABLED/TCP_TLS_ENABLED to listeners. TLS keeps
+// TCP_PORT when both are enabled, so plain TCP needs its own TCP_PLAIN_PORT.
+func legacyListeners(tcp TCPConfig, plainPort string) []ListenerConfig {
+	var listeners []ListenerConfig
+	if tcp.TLS.Enabled {
+		listeners = append(listeners, ListenerConfig{Address: ":" + tcp.Port, TLS: true})
+	}
+	if tcp.Enabled {
+		switch {
+		case !tcp.TLS.Enabled:
+			listeners = append(listeners, ListenerConfig{Address: ":" + tcp.Port})
+		case plainPort != "":
+			listeners = append(listeners, ListenerConfig{Address: ":" + plainPort})
+		}
+	}
+	return listeners
+}
+
 // getEnvWithDefault returns the environment variable value or the default if not set
 func getEnvWithDefault(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
 	}
 	return defaultValue
-} 
\ No newline at end of file
+}
+
+// splitList reads a comma-separated list such as "example.com, example.org",
+// lowercased and without empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
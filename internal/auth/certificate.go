@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"crypto/x509"
+)
+
+// CertificateIdentities lists the identities a client certificate can be
+// mapped by in the keys file: the subject common name and every SAN
+func CertificateIdentities(cert *x509.Certificate) []string {
+	var identities []string
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, "CN="+cert.Subject.CommonName)
+	}
+	for _, name := range cert.DNSNames {
+		identities = append(identities, "DNS:"+name)
+	}
+	for _, uri := range cert.URIs {
+		identities = append(identities, "URI:"+uri.String())
+	}
+	for _, email := range cert.EmailAddresses {
+		identities = append(identities, "EMAIL:"+email)
+	}
+	for _, ip := range cert.IPAddresses {
+		identities = append(identities, "IP:"+ip.String())
+	}
+	return identities
+}
@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Arturstriker3/api-go/config"
+	"github.com/Arturstriker3/api-go/internal/metrics"
+)
+
+var (
+	// ErrDenylisted is returned for addresses in the permanent denylist
+	ErrDenylisted = errors.New("address is denylisted")
+
+	// ErrBanned is returned for addresses temporarily banned after too many
+	// authentication failures
+	ErrBanned = errors.New("address is temporarily banned")
+)
+
+// BanInfo describes an active ban
+type BanInfo struct {
+	IP       string    `json:"ip"`
+	Until    time.Time `json:"until"`
+	Bans     int       `json:"bans"`
+	Failures int       `json:"failures"`
+}
+
+// clientRecord tracks the recent authentication failures of one address
+type clientRecord struct {
+	failures    []time.Time
+	bans        int
+	bannedUntil time.Time
+	lastSeen    time.Time
+}
+
+// Guard protects the authentication endpoints against brute force: failures
+// are counted per IP over a sliding window and repeat offenders are banned
+// for exponentially longer periods
+type Guard struct {
+	mu          sync.Mutex
+	maxFailures int
+	window      time.Duration
+	banDuration time.Duration
+	maxBan      time.Duration
+	allowlist   []*net.IPNet
+	denylist    []*net.IPNet
+	clients     map[string]*clientRecord
+}
+
+// NewGuard creates a guard from the auth configuration
+func NewGuard(cfg config.AuthConfig) (*Guard, error) {
+	allowlist, err := ParseCIDRs(cfg.Allowlist)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AUTH_ALLOWLIST: %w", err)
+	}
+	denylist, err := ParseCIDRs(cfg.Denylist)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AUTH_DENYLIST: %w", err)
+	}
+
+	return &Guard{
+		maxFailures: cfg.MaxFailures,
+		window:      cfg.FailureWindow,
+		banDuration: cfg.BanDuration,
+		maxBan:      cfg.MaxBanDuration,
+		allowlist:   allowlist,
+		denylist:    denylist,
+		clients:     make(map[string]*clientRecord),
+	}, nil
+}
+
+// Check reports whether the address may connect or authenticate
+func (g *Guard) Check(addr string) error {
+	ip := HostIP(addr)
+	parsed := net.ParseIP(ip)
+
+	if containsIP(g.denylist, parsed) {
+		metrics.AuthRejected.WithLabelValues("denylisted").Inc()
+		return ErrDenylisted
+	}
+	if containsIP(g.allowlist, parsed) {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if record, ok := g.clients[ip]; ok && time.Now().Before(record.bannedUntil) {
+		metrics.AuthRejected.WithLabelValues("banned").Inc()
+		return fmt.Errorf("%w until %s", ErrBanned, record.bannedUntil.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// RecordFailure counts a failed authentication and bans the address once it
+// exceeds the allowed failures within the window
+func (g *Guard) RecordFailure(addr string) {
+	if g.maxFailures <= 0 {
+		return
+	}
+
+	ip := HostIP(addr)
+	if containsIP(g.allowlist, net.ParseIP(ip)) {
+		return
+	}
+
+	now := time.Now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	record, ok := g.clients[ip]
+	if !ok {
+		record = &clientRecord{}
+		g.clients[ip] = record
+	}
+	record.lastSeen = now
+	record.failures = append(pruneFailures(record.failures, now.Add(-g.window)), now)
+
+	if len(record.failures) < g.maxFailures {
+		return
+	}
+
+	// Each new ban doubles the previous duration, up to the maximum. Doubling
+	// stops at the cap instead of shifting, which would overflow.
+	duration := min(g.banDuration, g.maxBan)
+	for i := 0; i < record.bans && duration < g.maxBan; i++ {
+		if duration > g.maxBan/2 {
+			duration = g.maxBan
+			break
+		}
+		duration *= 2
+	}
+	record.bans++
+	record.bannedUntil = now.Add(duration)
+	record.failures = nil
+
+	metrics.AuthBans.Inc()
+	g.updateActiveBans(now)
+	log.Printf("⛔ Banned %s for %s after %d authentication failures (ban #%d)", ip, duration, g.maxFailures, record.bans)
+}
+
+// RecordSuccess forgets the failures of an address after a good login. The
+// ban count is kept so a later offence is still punished harder.
+func (g *Guard) RecordSuccess(addr string) {
+	ip := HostIP(addr)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if record, ok := g.clients[ip]; ok {
+		record.failures = nil
+		record.lastSeen = time.Now()
+	}
+}
+
+// Bans lists the currently active bans
+func (g *Guard) Bans() []BanInfo {
+	now := time.Now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	bans := []BanInfo{}
+	for ip, record := range g.clients {
+		if now.Before(record.bannedUntil) {
+			bans = append(bans, BanInfo{IP: ip, Until: record.bannedUntil, Bans: record.bans, Failures: len(record.failures)})
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].IP < bans[j].IP })
+	return bans
+}
+
+// ClearBans lifts the ban and forgets the history of an address, or of every
+// address when ip is empty. It returns the number of bans lifted.
+func (g *Guard) ClearBans(ip string) int {
+	now := time.Now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	cleared := 0
+	for addr, record := range g.clients {
+		if ip != "" && addr != ip {
+			continue
+		}
+		if now.Before(record.bannedUntil) {
+			cleared++
+		}
+		delete(g.clients, addr)
+	}
+	g.updateActiveBans(now)
+	return cleared
+}
+
+// StartCleanup periodically forgets addresses whose bans and failures have
+// expired and refreshes the active bans gauge
+func (g *Guard) StartCleanup() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			now := time.Now()
+
+			g.mu.Lock()
+			for ip, record := range g.clients {
+				// Keep the ban count around for a while so repeat offenders
+				// still get longer bans
+				idle := now.Sub(record.lastSeen) > g.maxBan && now.After(record.bannedUntil)
+				if idle {
+					delete(g.clients, ip)
+				}
+			}
+			g.updateActiveBans(now)
+			g.mu.Unlock()
+		}
+	}()
+}
+
+// updateActiveBans refreshes the gauge. The caller must hold the lock.
+func (g *Guard) updateActiveBans(now time.Time) {
+	active := 0
+	for _, record := range g.clients {
+		if now.Before(record.bannedUntil) {
+			active++
+		}
+	}
+	metrics.AuthBansActive.Set(float64(active))
+}
+
+func pruneFailures(failures []time.Time, cutoff time.Time) []time.Time {
+	kept := failures[:0]
+	for _, at := range failures {
+		if at.After(cutoff) {
+			kept = append(kept, at)
+		}
+	}
+	return kept
+}
+
+// ParseCIDRs parses a comma-separated list of CIDRs or bare IP addresses
+func ParseCIDRs(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// HostIP strips the port from a remote address
+func HostIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,419 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Arturstriker3/api-go/pkg/protocol"
+)
+
+// Scope limits what an API key may do
+type Scope string
+
+const (
+	ScopeSend   Scope = "send"
+	ScopeStatus Scope = "status"
+	ScopeAdmin  Scope = "admin"
+)
+
+// LegacyKeyID identifies the key built from TCP_AUTH_SECRET
+const LegacyKeyID = "default"
+
+// hashPrefix marks the unsalted SHA-256 digests stored before SCRAM
+// verifiers; such keys can only authenticate with a clear-text secret
+const hashPrefix = "sha256:"
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrKeyDisabled        = errors.New("key is disabled")
+	ErrKeyExpired         = errors.New("key has expired")
+)
+
+// Key is one named credential loaded from the keys file
+type Key struct {
+	ID         string     `json:"id"`
+	SecretHash string     `json:"secret_hash"`
+	Enabled    bool       `json:"enabled"`
+	Scopes     []Scope    `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+
+	// Certificates lists client certificate identities mapped to this key,
+	// e.g. "CN=billing", "DNS:billing.internal" or "URI:spiffe://corp/billing"
+	Certificates []string `json:"certificates,omitempty"`
+
+	// Peers lists Unix socket peer credentials mapped to this key, as
+	// "uid:1000" or "gid:1001"
+	Peers []string `json:"peers,omitempty"`
+
+	// verifier is parsed from a "scram-sha256:" SecretHash
+	verifier *verifier
+	// verified caches the SHA-256 of the last secret that matched the
+	// verifier, so clients authenticating on every request only pay for
+	// PBKDF2 once
+	verified atomic.Pointer[[sha256.Size]byte]
+}
+
+// matchesSecret checks a clear-text secret against the stored hash
+func (k *Key) matchesSecret(secret string) bool {
+	if k.verifier == nil {
+		return subtle.ConstantTimeCompare([]byte(sha256Hash(secret)), []byte(k.SecretHash)) == 1
+	}
+
+	digest := sha256.Sum256([]byte(secret))
+	if cached := k.verified.Load(); cached != nil && subtle.ConstantTimeCompare(cached[:], digest[:]) == 1 {
+		return true
+	}
+	if !k.verifier.matches(secret) {
+		return false
+	}
+	k.verified.Store(&digest)
+	return true
+}
+
+// HasScope reports whether the key grants the scope
+func (k *Key) HasScope(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Usable reports why the key cannot be used right now, or nil if it can
+func (k *Key) Usable(now time.Time) error {
+	if !k.Enabled {
+		return ErrKeyDisabled
+	}
+	if k.ExpiresAt != nil && now.After(*k.ExpiresAt) {
+		return ErrKeyExpired
+	}
+	return nil
+}
+
+// keysFile is the on-disk format of the keys file
+type keysFile struct {
+	Keys []*Key `json:"keys"`
+}
+
+// KeyStore holds the API keys allowed to use the service
+type KeyStore struct {
+	mu             sync.RWMutex
+	path           string
+	legacyVerifier *verifier
+	keys           map[string]*Key
+
+	// decoySaltKey derives the salts handed out for unknown keys
+	decoySaltKey []byte
+}
+
+// LoadKeyStore reads the keys file at path. The legacy shared secret, when
+// set, is exposed as the "default" key with every scope so existing clients
+// keep working. Either argument may be empty, but not both.
+func LoadKeyStore(path, legacySecret string) (*KeyStore, error) {
+	store := &KeyStore{
+		path:         path,
+		decoySaltKey: make([]byte, 32),
+	}
+	if _, err := rand.Read(store.decoySaltKey); err != nil {
+		return nil, fmt.Errorf("failed to generate salt key: %w", err)
+	}
+	if legacySecret != "" {
+		v, err := newVerifier(legacySecret)
+		if err != nil {
+			return nil, err
+		}
+		store.legacyVerifier = v
+	}
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Reload re-reads the keys file, so keys can be added, disabled or revoked
+// without a restart
+func (s *KeyStore) Reload() error {
+	keys := make(map[string]*Key)
+
+	if s.legacyVerifier != nil {
+		keys[LegacyKeyID] = &Key{
+			ID:         LegacyKeyID,
+			SecretHash: s.legacyVerifier.String(),
+			Enabled:    true,
+			Scopes:     []Scope{ScopeSend, ScopeStatus, ScopeAdmin},
+			verifier:   s.legacyVerifier,
+		}
+	}
+
+	if s.path != "" {
+		data, err := os.ReadFile(s.path)
+		if err != nil {
+			return fmt.Errorf("failed to read keys file: %w", err)
+		}
+
+		var file keysFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return fmt.Errorf("failed to parse keys file: %w", err)
+		}
+
+		for _, key := range file.Keys {
+			if key.ID == "" {
+				return fmt.Errorf("keys file contains a key without an id")
+			}
+			switch {
+			case strings.HasPrefix(key.SecretHash, scramPrefix):
+				v, err := parseVerifier(key.SecretHash)
+				if err != nil {
+					return fmt.Errorf("key %q: invalid secret_hash: %w", key.ID, err)
+				}
+				key.verifier = v
+			case strings.HasPrefix(key.SecretHash, hashPrefix):
+				log.Printf("🟡 Key %q has an unsalted sha256 secret_hash and cannot answer challenges; regenerate it with scripts/generate-api-key.go", key.ID)
+			default:
+				return fmt.Errorf("key %q: secret_hash must start with %q", key.ID, scramPrefix)
+			}
+			if _, exists := keys[key.ID]; exists {
+				return fmt.Errorf("duplicate key id %q", key.ID)
+			}
+			for _, identity := range key.Certificates {
+				if owner := certificateOwner(keys, identity); owner != "" {
+					return fmt.Errorf("certificate identity %q is mapped to both %q and %q", identity, owner, key.ID)
+				}
+			}
+			for _, identity := range key.Peers {
+				if !validPeerIdentity(identity) {
+					return fmt.Errorf("key %q: peer %q must look like uid:<number> or gid:<number>", key.ID, identity)
+				}
+				if owner := peerOwner(keys, identity); owner != "" {
+					return fmt.Errorf("peer %q is mapped to both %q and %q", identity, owner, key.ID)
+				}
+			}
+			keys[key.ID] = key
+		}
+	}
+
+	if len(keys) == 0 {
+		return fmt.Errorf("no API keys configured")
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+	return nil
+}
+
+// Authenticate checks a secret against the key with the given ID. When keyID
+// is empty every key is tried, which lets legacy clients that only send a
+// secret authenticate with any key.
+func (s *KeyStore) Authenticate(keyID, secret string) (*Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matched *Key
+	if keyID != "" {
+		key, ok := s.keys[keyID]
+		if !ok || !key.matchesSecret(secret) {
+			return nil, ErrInvalidCredentials
+		}
+		matched = key
+	} else {
+		// Compare against every key so timing does not reveal which one matched
+		for _, key := range s.keys {
+			if key.matchesSecret(secret) {
+				matched = key
+			}
+		}
+		if matched == nil {
+			return nil, ErrInvalidCredentials
+		}
+	}
+
+	if err := matched.Usable(time.Now()); err != nil {
+		return nil, err
+	}
+	return matched, nil
+}
+
+// Challenge returns the salt and iteration count a client needs to answer a
+// challenge for the key. Unknown keys, and keys without a SCRAM verifier, get
+// a stable salt derived from their ID so the answer does not reveal which
+// keys exist.
+func (s *KeyStore) Challenge(keyID string) (salt []byte, iterations int) {
+	s.mu.RLock()
+	key, ok := s.keys[keyID]
+	s.mu.RUnlock()
+
+	if ok && key.verifier != nil {
+		return key.verifier.salt, key.verifier.iterations
+	}
+	mac := hmac.New(sha256.New, s.decoySaltKey)
+	mac.Write([]byte(keyID))
+	return mac.Sum(nil)[:16], SCRAMIterations
+}
+
+// VerifyChallenge checks a SCRAM-SHA-256 proof over a nonce issued by the
+// server and returns the server signature proving the store knows the key
+// too. The stored verifier cannot produce a valid proof, so reading the keys
+// file is not enough to log in.
+func (s *KeyStore) VerifyChallenge(keyID, nonce, proof string) (*Key, string, error) {
+	s.mu.RLock()
+	key, ok := s.keys[keyID]
+	s.mu.RUnlock()
+
+	if !ok || key.verifier == nil || !key.verifier.verifyProof(keyID, nonce, proof) {
+		return nil, "", ErrInvalidCredentials
+	}
+	if err := key.Usable(time.Now()); err != nil {
+		return nil, "", err
+	}
+	return key, protocol.SCRAMServerSignature(key.verifier.serverKey, keyID, nonce), nil
+}
+
+// LookupCertificate finds the key mapped to any of the identities of a
+// verified client certificate
+func (s *KeyStore) LookupCertificate(identities []string) (*Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, identity := range identities {
+		if owner := certificateOwner(s.keys, identity); owner != "" {
+			key := s.keys[owner]
+			if err := key.Usable(time.Now()); err != nil {
+				return nil, err
+			}
+			return key, nil
+		}
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// certificateOwner returns the ID of the key a certificate identity is
+// mapped to, or "" if none
+func certificateOwner(keys map[string]*Key, identity string) string {
+	for _, key := range keys {
+		for _, mapped := range key.Certificates {
+			if strings.EqualFold(mapped, identity) {
+				return key.ID
+			}
+		}
+	}
+	return ""
+}
+
+// LookupPeer finds the key mapped to the credentials of a Unix socket peer.
+// Identities are tried in order, so a uid mapping wins over a gid mapping.
+func (s *KeyStore) LookupPeer(identities []string) (*Key, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, identity := range identities {
+		if owner := peerOwner(s.keys, identity); owner != "" {
+			key := s.keys[owner]
+			if err := key.Usable(time.Now()); err != nil {
+				return nil, err
+			}
+			return key, nil
+		}
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// peerOwner returns the ID of the key a peer identity is mapped to, or "" if
+// none
+func peerOwner(keys map[string]*Key, identity string) string {
+	for _, key := range keys {
+		for _, mapped := range key.Peers {
+			if mapped == identity {
+				return key.ID
+			}
+		}
+	}
+	return ""
+}
+
+func validPeerIdentity(identity string) bool {
+	kind, id, found := strings.Cut(identity, ":")
+	if !found || (kind != "uid" && kind != "gid") {
+		return false
+	}
+	_, err := strconv.ParseUint(id, 10, 32)
+	return err == nil
+}
+
+// Get returns the current definition of a key
+func (s *KeyStore) Get(keyID string) (*Key, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.keys[keyID]
+	return key, ok
+}
+
+// Authorize checks that the key still exists, is usable and grants the scope.
+// It is evaluated on every request so revocations apply to open connections.
+func (s *KeyStore) Authorize(keyID string, scope Scope) error {
+	key, ok := s.Get(keyID)
+	if !ok {
+		return ErrInvalidCredentials
+	}
+	if err := key.Usable(time.Now()); err != nil {
+		return err
+	}
+	if !key.HasScope(scope) {
+		return fmt.Errorf("key %q lacks the %q scope", keyID, scope)
+	}
+	return nil
+}
+
+// StartWatcher reloads the keys file whenever it changes
+func (s *KeyStore) StartWatcher() {
+	if s.path == "" {
+		return
+	}
+
+	go func() {
+		var lastModTime time.Time
+		if stat, err := os.Stat(s.path); err == nil {
+			lastModTime = stat.ModTime()
+		}
+
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			stat, err := os.Stat(s.path)
+			if err != nil || !stat.ModTime().After(lastModTime) {
+				continue
+			}
+			lastModTime = stat.ModTime()
+
+			if err := s.Reload(); err != nil {
+				log.Printf("🔴 Failed to reload API keys: %v", err)
+				continue
+			}
+			log.Println("✅ API keys reloaded")
+		}
+	}()
+
+	log.Printf("🔍 API keys watcher started - monitoring %s every 30s", s.path)
+}
+
+// sha256Hash returns the legacy "sha256:" representation of a secret
+func sha256Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hashPrefix + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Arturstriker3/api-go/pkg/protocol"
+)
+
+// scramPrefix marks a SCRAM-SHA-256 verifier, stored as
+// "scram-sha256:<iterations>:<salt>:<stored key>:<server key>" in base64
+const scramPrefix = "scram-sha256:"
+
+// SCRAMIterations is the PBKDF2 iteration count of new verifiers
+const SCRAMIterations = 10000
+
+// verifier holds what the server keeps of a secret. It can check a secret
+// or a challenge proof but cannot be used to compute a proof.
+type verifier struct {
+	iterations int
+	salt       []byte
+	storedKey  []byte
+	serverKey  []byte
+}
+
+// NewVerifier derives the stored representation of a secret with a random
+// salt
+func NewVerifier(secret string) (string, error) {
+	v, err := newVerifier(secret)
+	if err != nil {
+		return "", err
+	}
+	return v.String(), nil
+}
+
+func newVerifier(secret string) (*verifier, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	_, storedKey, serverKey, err := protocol.SCRAMKeys(secret, salt, SCRAMIterations)
+	if err != nil {
+		return nil, err
+	}
+	return &verifier{iterations: SCRAMIterations, salt: salt, storedKey: storedKey, serverKey: serverKey}, nil
+}
+
+func (v *verifier) String() string {
+	encode := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("%s%d:%s:%s:%s", scramPrefix, v.iterations, encode(v.salt), encode(v.storedKey), encode(v.serverKey))
+}
+
+func parseVerifier(stored string) (*verifier, error) {
+	parts := strings.Split(strings.TrimPrefix(stored, scramPrefix), ":")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("expected %q<iterations>:<salt>:<stored key>:<server key>", scramPrefix)
+	}
+
+	iterations, err := strconv.Atoi(parts[0])
+	if err != nil || iterations < protocol.MinSCRAMIterations {
+		return nil, fmt.Errorf("iteration count must be a number of at least %d", protocol.MinSCRAMIterations)
+	}
+	v := &verifier{iterations: iterations}
+	for i, field := range []*[]byte{&v.salt, &v.storedKey, &v.serverKey} {
+		if *field, err = base64.StdEncoding.DecodeString(parts[i+1]); err != nil {
+			return nil, fmt.Errorf("invalid base64: %w", err)
+		}
+	}
+	if len(v.salt) == 0 || len(v.storedKey) != sha256.Size || len(v.serverKey) != sha256.Size {
+		return nil, fmt.Errorf("salt must not be empty and keys must be %d bytes", sha256.Size)
+	}
+	return v, nil
+}
+
+// matches reports whether secret is the secret the verifier was derived from
+func (v *verifier) matches(secret string) bool {
+	_, storedKey, _, err := protocol.SCRAMKeys(secret, v.salt, v.iterations)
+	return err == nil && subtle.ConstantTimeCompare(storedKey, v.storedKey) == 1
+}
+
+// verifyProof recovers the client key from a challenge proof and checks it
+// hashes to the stored key
+func (v *verifier) verifyProof(keyID, nonce, proof string) bool {
+	clientKey, err := base64.StdEncoding.DecodeString(proof)
+	if err != nil || len(clientKey) != sha256.Size {
+		return false
+	}
+	signature := protocol.SCRAMClientSignature(v.storedKey, keyID, nonce)
+	for i := range clientKey {
+		clientKey[i] ^= signature[i]
+	}
+	storedKey := sha256.Sum256(clientKey)
+	return hmac.Equal(storedKey[:], v.storedKey)
+}
@@ -3,25 +3,64 @@ package email
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"mime"
+	"net/textproto"
+	"sync/atomic"
 	"time"
 
 	"github.com/Arturstriker3/api-go/config"
+	"github.com/Arturstriker3/api-go/internal/htmltext"
 	"github.com/Arturstriker3/api-go/internal/metrics"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"gopkg.in/gomail.v2"
 )
 
 type EmailData struct {
+	MessageID string    `json:"message_id,omitempty"`
 	To        []string  `json:"to"`
+	Cc        []string  `json:"cc,omitempty"`
+	Bcc       []string  `json:"bcc,omitempty"`
+	ReplyTo   string    `json:"reply_to,omitempty"`
 	Subject   string    `json:"subject"`
 	Body      string    `json:"body"`
 	QueuedAt  time.Time `json:"queued_at"`
+
+	// TextBody is the plain-text alternative of Body; it is generated from
+	// the HTML when empty. Without Body the email is sent as plain text only.
+	TextBody string `json:"text_body,omitempty"`
+
+	// Attachments travel base64-encoded through the queue
+	Attachments []Attachment `json:"attachments,omitempty"`
+
+	// Headers are extra headers such as List-Unsubscribe; the headers the
+	// service sets itself are protected, see ValidateHeaders
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// IdempotencyKey lets a client retry a send without queueing the email
+	// twice; it is scoped to the client's API key
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// Attachment is a file sent along with the email. Content is base64 in JSON.
+// Attachments with a ContentID are embedded inline instead, for the HTML body
+// to reference as "cid:<content id>".
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Content     []byte `json:"content"`
+	ContentID   string `json:"content_id,omitempty"`
 }
 
 type Service struct {
 	config   *config.Config
 	dialer   *gomail.Dialer
+	conn     *amqp.Connection
 	channel  *amqp.Channel
+	statuses *StatusStore
+	// backlog is the number of messages waiting in the queue, as last
+	// reported by the consumer
+	backlog atomic.Int64
 }
 
 func NewEmailService(cfg *config.Config) *Service {
@@ -62,27 +101,58 @@ func NewEmailService(cfg *config.Config) *Service {
 	}
 
 	return &Service{
-		config:  cfg,
-		dialer:  dialer,
-		channel: ch,
+		config:   cfg,
+		dialer:   dialer,
+		conn:     conn,
+		channel:  ch,
+		statuses: NewStatusStore(cfg.Status.Retention),
 	}
 }
 
-// QueueEmail adds the email to the RabbitMQ queue
-func (s *Service) QueueEmail(data *EmailData) error {
-	if len(data.To) == 0 {
+// Close closes the publishing channel and the RabbitMQ connection. Call it
+// once nothing can queue emails anymore.
+func (s *Service) Close() {
+	if s.channel != nil {
+		s.channel.Close()
+	}
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+// Statuses returns the store tracking the delivery state of queued messages
+func (s *Service) Statuses() *StatusStore {
+	return s.statuses
+}
+
+// SetBacklog records the number of messages waiting in the queue
+func (s *Service) SetBacklog(messages int) {
+	s.backlog.Store(int64(messages))
+}
+
+// Backlog returns the number of messages waiting in the queue when it was
+// last inspected
+func (s *Service) Backlog() int {
+	return int(s.backlog.Load())
+}
+
+// QueueEmail adds the email to the RabbitMQ queue on behalf of the API key
+// keyID and returns the message ID assigned to it
+func (s *Service) QueueEmail(data *EmailData, keyID string) (string, error) {
+	if err := data.Validate(s.config.Email); err != nil {
 		metrics.EmailErrors.Inc()
-		return fmt.Errorf("recipient list is empty")
+		return "", err
 	}
 
-	// Add timestamp when queueing
+	// Add message ID and timestamp when queueing
+	data.MessageID = NewMessageID()
 	data.QueuedAt = time.Now()
 
 	// Convert email data to JSON
 	body, err := json.Marshal(data)
 	if err != nil {
 		metrics.EmailErrors.Inc()
-		return fmt.Errorf("failed to marshal email data: %w", err)
+		return "", fmt.Errorf("failed to marshal email data: %w", err)
 	}
 
 	// Publish to queue
@@ -93,35 +163,100 @@ func (s *Service) QueueEmail(data *EmailData) error {
 		false,        // immediate
 		amqp.Publishing{
 			ContentType: "application/json",
+			MessageId:   data.MessageID,
+			Timestamp:   data.QueuedAt,
 			Body:        body,
 		})
 
 	if err != nil {
 		metrics.EmailErrors.Inc()
-		return fmt.Errorf("failed to publish to queue: %w", err)
+		return "", fmt.Errorf("failed to publish to queue: %w", err)
 	}
 
+	s.statuses.RecordQueued(data.MessageID, keyID)
 	metrics.EmailsQueued.Inc()
-	return nil
+	return data.MessageID, nil
+}
+
+// attach adds an attachment from memory; gomail only reads files from disk.
+// Inline attachments go into a multipart/related part next to the HTML body.
+func attach(m *gomail.Message, attachment Attachment) {
+	mediaType, params, _ := mime.ParseMediaType(attachment.ContentType)
+	if params == nil {
+		params = make(map[string]string)
+	}
+	params["name"] = attachment.Filename
+
+	header := map[string][]string{
+		"Content-Type": {mime.FormatMediaType(mediaType, params)},
+	}
+	content := attachment.Content
+	copyFunc := gomail.SetCopyFunc(func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	})
+
+	if attachment.ContentID != "" {
+		header["Content-ID"] = []string{"<" + attachment.ContentID + ">"}
+		m.Embed(attachment.Filename, gomail.SetHeader(header), copyFunc)
+		return
+	}
+	m.Attach(attachment.Filename, gomail.SetHeader(header), copyFunc)
 }
 
 // SendEmail sends the email directly via SMTP (used by the consumer)
 func (s *Service) SendEmail(data *EmailData) error {
-	if len(data.To) == 0 {
+	if data.RecipientCount() == 0 {
 		metrics.EmailErrors.Inc()
 		return fmt.Errorf("recipient list is empty")
 	}
 
 	m := gomail.NewMessage()
 	m.SetHeader("From", s.config.SMTP.From)
-	m.SetHeader("To", data.To...)
+	if len(data.To) > 0 {
+		m.SetHeader("To", data.To...)
+	}
+	if len(data.Cc) > 0 {
+		m.SetHeader("Cc", data.Cc...)
+	}
+	// gomail adds Bcc recipients to the SMTP envelope but never writes the
+	// header
+	if len(data.Bcc) > 0 {
+		m.SetHeader("Bcc", data.Bcc...)
+	}
+	if data.ReplyTo != "" {
+		m.SetHeader("Reply-To", data.ReplyTo)
+	}
 	m.SetHeader("Subject", data.Subject)
-	m.SetBody("text/html", data.Body)
+	if data.MessageID != "" {
+		m.SetHeader("Message-ID", messageIDHeader(data.MessageID, s.config.SMTP.From))
+	}
+	for name, value := range data.Headers {
+		// gomail turns non-ASCII values into RFC 2047 encoded words
+		m.SetHeader(textproto.CanonicalMIMEHeaderKey(name), value)
+	}
+	if data.Body == "" {
+		m.SetBody("text/plain", data.TextBody)
+	} else {
+		text := data.TextBody
+		if text == "" {
+			text = htmltext.Convert(data.Body)
+		}
+		// Clients show the last alternative they support, so HTML goes last
+		m.SetBody("text/plain", text)
+		m.AddAlternative("text/html", data.Body)
+	}
+	for _, attachment := range data.Attachments {
+		attach(m, attachment)
+	}
 
+	s.statuses.Record(data.MessageID, StateSending, "")
 	if err := s.dialer.DialAndSend(m); err != nil {
 		metrics.EmailErrors.Inc()
+		s.statuses.Record(data.MessageID, StateFailed, err.Error())
 		return fmt.Errorf("failed to send email: %w", err)
 	}
+	s.statuses.Record(data.MessageID, StateSent, "")
 
 	// Calculate delivery time if timestamp exists
 	if !data.QueuedAt.IsZero() {
@@ -0,0 +1,200 @@
+package email
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Delivery states recorded for every queued message
+const (
+	StateQueued    = "queued"
+	StateSending   = "sending"
+	StateSent      = "sent"
+	StateFailed    = "failed"
+	StateCancelled = "cancelled"
+)
+
+var (
+	// ErrMessageNotFound is returned when no status is known for a message ID
+	ErrMessageNotFound = errors.New("message not found")
+
+	// ErrNotCancellable is returned when a message already left the queue
+	ErrNotCancellable = errors.New("message can no longer be cancelled")
+)
+
+// StatusEvent is one state transition of a message
+type StatusEvent struct {
+	State  string    `json:"state"`
+	Reason string    `json:"reason,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// MessageStatus is the delivery history of one message
+type MessageStatus struct {
+	MessageID string        `json:"message_id"`
+	State     string        `json:"state"`
+	Reason    string        `json:"reason,omitempty"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	History   []StatusEvent `json:"history"`
+
+	// KeyID is the API key that queued the message; only it, or an admin
+	// key, may read or cancel the message
+	KeyID string `json:"key_id,omitempty"`
+}
+
+// StatusStore keeps the delivery state of recent messages in memory. Entries
+// are dropped once they have not changed for the retention period.
+type StatusStore struct {
+	mu        sync.RWMutex
+	statuses  map[string]*MessageStatus
+	retention time.Duration
+	lastPrune time.Time
+}
+
+// NewStatusStore creates an empty store
+func NewStatusStore(retention time.Duration) *StatusStore {
+	return &StatusStore{
+		statuses:  make(map[string]*MessageStatus),
+		retention: retention,
+		lastPrune: time.Now(),
+	}
+}
+
+// RecordQueued records a newly queued message and the API key that queued it
+func (s *StatusStore) RecordQueued(messageID, keyID string) {
+	s.record(messageID, keyID, StateQueued, "")
+}
+
+// Record appends a state transition for the message
+func (s *StatusStore) Record(messageID, state, reason string) {
+	s.record(messageID, "", state, reason)
+}
+
+func (s *StatusStore) record(messageID, keyID, state, reason string) {
+	if messageID == "" {
+		return
+	}
+
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status, ok := s.statuses[messageID]
+	if !ok {
+		status = &MessageStatus{MessageID: messageID, KeyID: keyID}
+		s.statuses[messageID] = status
+	}
+	status.State = state
+	status.Reason = reason
+	status.UpdatedAt = now
+	status.History = append(status.History, StatusEvent{State: state, Reason: reason, At: now})
+
+	if now.Sub(s.lastPrune) > time.Minute {
+		s.prune(now)
+	}
+}
+
+// Get returns a copy of the status for the message
+func (s *StatusStore) Get(messageID string) (*MessageStatus, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	status, ok := s.statuses[messageID]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+
+	copied := *status
+	copied.History = append([]StatusEvent(nil), status.History...)
+	return &copied, nil
+}
+
+// Cancel marks a message that is still waiting in the queue as cancelled.
+// The consumer skips cancelled messages instead of sending them.
+func (s *StatusStore) Cancel(messageID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status, ok := s.statuses[messageID]
+	if !ok {
+		return ErrMessageNotFound
+	}
+	switch status.State {
+	case StateQueued, StateFailed:
+	default:
+		return ErrNotCancellable
+	}
+
+	now := time.Now()
+	status.State = StateCancelled
+	status.Reason = "cancelled by client"
+	status.UpdatedAt = now
+	status.History = append(status.History, StatusEvent{State: StateCancelled, Reason: status.Reason, At: now})
+	return nil
+}
+
+// IsCancelled reports whether the message was cancelled before sending
+func (s *StatusStore) IsCancelled(messageID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	status, ok := s.statuses[messageID]
+	return ok && status.State == StateCancelled
+}
+
+// prune removes entries that have not been updated within the retention
+// period. The caller must hold the write lock.
+func (s *StatusStore) prune(now time.Time) {
+	s.lastPrune = now
+	if s.retention <= 0 {
+		return
+	}
+	for id, status := range s.statuses {
+		if now.Sub(status.UpdatedAt) > s.retention {
+			delete(s.statuses, id)
+		}
+	}
+}
+
+// NewMessageID generates a random, URL-safe message identifier
+func NewMessageID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		// crypto/rand never fails on supported platforms; fall back to time
+		return hex.EncodeToString([]byte(time.Now().Format(time.RFC3339Nano)))
+	}
+	return hex.EncodeToString(buf)
+}
+
+// messageIDHeader formats a message ID as an RFC 5322 Message-ID using the
+// domain of the sender address
+func messageIDHeader(messageID, from string) string {
+	domain := "gomailer.local"
+	if at := strings.LastIndex(from, "@"); at >= 0 && at < len(from)-1 {
+		domain = strings.Trim(from[at+1:], "> ")
+	}
+	return "<" + messageID + "@" + domain + ">"
+}
+
+// MessageIDFromHeader extracts the message ID from a Message-ID set by
+// messageIDHeader, as quoted in the In-Reply-To of a reply. It reports false
+// for Message-IDs that were not generated by GoMailer.
+func MessageIDFromHeader(header string) (string, bool) {
+	header = strings.TrimSpace(header)
+	if !strings.HasPrefix(header, "<") || !strings.HasSuffix(header, ">") {
+		return "", false
+	}
+	id, _, found := strings.Cut(header[1:len(header)-1], "@")
+	if !found || len(id) != 32 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(id); err != nil {
+		return "", false
+	}
+	return id, true
+}
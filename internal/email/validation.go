@@ -0,0 +1,278 @@
+package email
+
+import (
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Arturstriker3/api-go/config"
+)
+
+// ValidationError reports a problem with the email data submitted by a client
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+// SizeError reports attachments over the configured size limits
+type SizeError struct {
+	Field string
+	Size  int
+	Limit int
+}
+
+func (e *SizeError) Error() string {
+	return fmt.Sprintf("%s is %d bytes, maximum is %d", e.Field, e.Size, e.Limit)
+}
+
+// blockedExtensions are refused whatever content type they claim, since mail
+// clients open them by extension
+var blockedExtensions = []string{
+	".exe", ".com", ".scr", ".pif", ".msi", ".msp", ".dll", ".cpl", ".bat", ".cmd",
+	".ps1", ".vbs", ".vbe", ".js", ".jse", ".wsf", ".wsh", ".hta", ".jar", ".lnk",
+	".reg", ".sh", ".app", ".iso", ".img",
+}
+
+// Limits on custom headers. Values are kept well under the 998 characters
+// allowed on a header line, leaving room for the name and RFC 2047 encoding.
+const (
+	MaxHeaders           = 50
+	MaxHeaderValueLength = 500
+)
+
+// protectedHeaders are set by the service or by the servers relaying the
+// email, and cannot be overridden through custom headers
+var protectedHeaders = []string{
+	"from", "sender", "to", "cc", "bcc", "reply-to", "subject", "date", "message-id",
+	"in-reply-to", "references", "mime-version", "return-path", "received", "received-spf",
+	"delivered-to", "authentication-results", "dkim-signature", "domainkey-signature",
+	"x-google-dkim-signature", "disposition-notification-to", "return-receipt-to",
+}
+
+// protectedHeaderPrefixes cover header families: MIME structure, resent
+// traces and authenticated received chains
+var protectedHeaderPrefixes = []string{"content-", "resent-", "arc-"}
+
+// MaxIdempotencyKeyLength bounds the idempotency keys clients may send
+const MaxIdempotencyKeyLength = 255
+
+// Validate checks that the email data can be queued within the configured
+// limits
+func (d *EmailData) Validate(limits config.EmailConfig) error {
+	if d.RecipientCount() == 0 {
+		return &ValidationError{Field: "to", Reason: "recipient list is empty"}
+	}
+	if limits.MaxRecipients > 0 && d.RecipientCount() > limits.MaxRecipients {
+		return &ValidationError{Field: "recipients", Reason: fmt.Sprintf("%d recipients in to, cc and bcc, maximum is %d", d.RecipientCount(), limits.MaxRecipients)}
+	}
+	if d.Body == "" && d.TextBody == "" {
+		return &ValidationError{Field: "body", Reason: "body or text_body is required"}
+	}
+	if err := validateAddresses("to", d.To); err != nil {
+		return err
+	}
+	if err := validateAddresses("cc", d.Cc); err != nil {
+		return err
+	}
+	if err := validateAddresses("bcc", d.Bcc); err != nil {
+		return err
+	}
+	if d.ReplyTo != "" {
+		if err := validateAddresses("reply_to", []string{d.ReplyTo}); err != nil {
+			return err
+		}
+	}
+	if err := validateAttachments(d.Attachments, limits); err != nil {
+		return err
+	}
+	if err := validateContentIDs(d.Body, d.Attachments); err != nil {
+		return err
+	}
+	if err := ValidateHeaders(d.Headers); err != nil {
+		return err
+	}
+	if len(d.IdempotencyKey) > MaxIdempotencyKeyLength {
+		return &ValidationError{Field: "idempotency_key", Reason: fmt.Sprintf("longer than %d characters", MaxIdempotencyKeyLength)}
+	}
+	return nil
+}
+
+// RecipientCount is the number of addresses in to, cc and bcc combined
+func (d *EmailData) RecipientCount() int {
+	return len(d.To) + len(d.Cc) + len(d.Bcc)
+}
+
+func validateAttachments(attachments []Attachment, limits config.EmailConfig) error {
+	total := 0
+	for i, attachment := range attachments {
+		field := fmt.Sprintf("attachments[%d]", i)
+
+		name := attachment.Filename
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "\r\n\x00/\\") {
+			return &ValidationError{Field: field, Reason: fmt.Sprintf("invalid filename %q", name)}
+		}
+		// Windows drops trailing dots and spaces, so "invoice.exe." opens as
+		// "invoice.exe"
+		if slices.Contains(blockedExtensions, strings.ToLower(filepath.Ext(strings.TrimRight(name, ". ")))) {
+			return &ValidationError{Field: field, Reason: fmt.Sprintf("%q has a blocked file extension", name)}
+		}
+
+		mediaType, _, err := mime.ParseMediaType(attachment.ContentType)
+		if err != nil {
+			return &ValidationError{Field: field, Reason: fmt.Sprintf("invalid content type %q", attachment.ContentType)}
+		}
+		if !slices.Contains(limits.AttachmentTypes, mediaType) {
+			return &ValidationError{Field: field, Reason: fmt.Sprintf("content type %s is not allowed", mediaType)}
+		}
+
+		if len(attachment.Content) == 0 {
+			return &ValidationError{Field: field, Reason: "content is empty"}
+		}
+		if attachment.ContentID != "" {
+			if !validContentID(attachment.ContentID) {
+				return &ValidationError{Field: field, Reason: fmt.Sprintf("invalid content_id %q", attachment.ContentID)}
+			}
+			if !strings.HasPrefix(mediaType, "image/") {
+				return &ValidationError{Field: field, Reason: "only images can be inline"}
+			}
+		}
+
+		if limits.MaxAttachmentSize > 0 && len(attachment.Content) > limits.MaxAttachmentSize {
+			return &SizeError{Field: field, Size: len(attachment.Content), Limit: limits.MaxAttachmentSize}
+		}
+		total += len(attachment.Content)
+	}
+
+	if limits.MaxAttachmentsSize > 0 && total > limits.MaxAttachmentsSize {
+		return &SizeError{Field: "attachments", Size: total, Limit: limits.MaxAttachmentsSize}
+	}
+	return nil
+}
+
+// cidReference matches "cid:" URLs in src and href attributes and CSS url()
+var cidReference = regexp.MustCompile(`(?i)\bcid:([^"'\s()<>]+)`)
+
+// ContentIDReferences returns the content IDs the HTML body references
+// through "cid:" URLs, in order of appearance and without repeats
+func ContentIDReferences(body string) []string {
+	var ids []string
+	for _, match := range cidReference.FindAllStringSubmatch(body, -1) {
+		id, err := url.PathUnescape(match[1])
+		if err != nil {
+			id = match[1]
+		}
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// validateContentIDs checks that content IDs are unique and that every
+// "cid:" reference in the body has an inline attachment to resolve to
+func validateContentIDs(body string, attachments []Attachment) error {
+	contentIDs := make(map[string]bool)
+	for i, attachment := range attachments {
+		if attachment.ContentID == "" {
+			continue
+		}
+		if body == "" {
+			return &ValidationError{Field: fmt.Sprintf("attachments[%d]", i), Reason: "inline images need an HTML body"}
+		}
+		if contentIDs[attachment.ContentID] {
+			return &ValidationError{Field: fmt.Sprintf("attachments[%d]", i), Reason: fmt.Sprintf("duplicate content_id %q", attachment.ContentID)}
+		}
+		contentIDs[attachment.ContentID] = true
+	}
+
+	for _, id := range ContentIDReferences(body) {
+		if !contentIDs[id] {
+			return &ValidationError{Field: "body", Reason: fmt.Sprintf("cid:%s has no inline attachment with that content_id", id)}
+		}
+	}
+	return nil
+}
+
+// validContentID accepts the characters of an RFC 5322 msg-id without the
+// angle brackets
+func validContentID(id string) bool {
+	if len(id) > 255 {
+		return false
+	}
+	for _, r := range id {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune(`<>()[]\",;'`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateHeaders checks custom headers: names must be valid RFC 5322 field
+// names outside the protected set, and values must fit on one line so they
+// cannot inject headers of their own
+func ValidateHeaders(headers map[string]string) error {
+	if len(headers) > MaxHeaders {
+		return &ValidationError{Field: "headers", Reason: fmt.Sprintf("%d headers, maximum is %d", len(headers), MaxHeaders)}
+	}
+
+	seen := make(map[string]bool, len(headers))
+	for name, value := range headers {
+		if !validHeaderName(name) {
+			return &ValidationError{Field: "headers", Reason: fmt.Sprintf("invalid header name %q", name)}
+		}
+
+		lower := strings.ToLower(name)
+		if slices.Contains(protectedHeaders, lower) || slices.ContainsFunc(protectedHeaderPrefixes, func(prefix string) bool {
+			return strings.HasPrefix(lower, prefix)
+		}) {
+			return &ValidationError{Field: "headers", Reason: fmt.Sprintf("%s cannot be set", name)}
+		}
+		if seen[lower] {
+			return &ValidationError{Field: "headers", Reason: fmt.Sprintf("%s is set more than once", name)}
+		}
+		seen[lower] = true
+
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return &ValidationError{Field: "headers", Reason: fmt.Sprintf("%s contains a line break", name)}
+		}
+		if !utf8.ValidString(value) {
+			return &ValidationError{Field: "headers", Reason: fmt.Sprintf("%s is not valid UTF-8", name)}
+		}
+		if len(value) > MaxHeaderValueLength {
+			return &ValidationError{Field: "headers", Reason: fmt.Sprintf("%s is longer than %d bytes", name, MaxHeaderValueLength)}
+		}
+	}
+	return nil
+}
+
+// validHeaderName accepts printable ASCII except the colon (RFC 5322 ftext)
+func validHeaderName(name string) bool {
+	if name == "" || len(name) > 76 {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] < 33 || name[i] > 126 || name[i] == ':' {
+			return false
+		}
+	}
+	return true
+}
+
+func validateAddresses(field string, addresses []string) error {
+	for _, address := range addresses {
+		if _, err := mail.ParseAddress(address); err != nil {
+			return &ValidationError{Field: field, Reason: fmt.Sprintf("%q is not a valid address", address)}
+		}
+	}
+	return nil
+}
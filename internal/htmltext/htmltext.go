@@ -0,0 +1,421 @@
+// Package htmltext renders HTML email bodies as readable plain text for the
+// text/plain alternative of outgoing emails
+package htmltext
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Elements whose content is never shown
+var hiddenElements = map[string]bool{"head": true, "title": true, "style": true, "script": true, "template": true}
+
+// Elements separated from the text around them by a blank line
+var paragraphElements = map[string]bool{
+	"p": true, "table": true, "blockquote": true, "dl": true, "figure": true,
+	"h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// Elements that start and end a line
+var lineElements = map[string]bool{
+	"div": true, "tr": true, "section": true, "article": true, "header": true, "footer": true,
+	"main": true, "nav": true, "aside": true, "center": true, "address": true, "dt": true, "dd": true,
+	"figcaption": true, "caption": true, "form": true, "fieldset": true,
+}
+
+var (
+	attributePattern = regexp.MustCompile(`([a-zA-Z_:][-a-zA-Z0-9_:.]*)\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+)`)
+	blankLines       = regexp.MustCompile(`\n{3,}`)
+)
+
+// Convert renders an HTML body as plain text. Headings are underlined, list
+// items are bulleted or numbered, links are followed by their URL in
+// parentheses and images are replaced by their alt text.
+func Convert(body string) string {
+	c := &converter{}
+	c.push()
+
+	for len(body) > 0 {
+		start := tagStart(body)
+		if start < 0 {
+			c.text(body)
+			break
+		}
+		c.text(body[:start])
+		body = body[start:]
+
+		if strings.HasPrefix(body, "<!--") {
+			end := strings.Index(body, "-->")
+			if end < 0 {
+				break
+			}
+			body = body[end+3:]
+			continue
+		}
+
+		end := tagEnd(body)
+		if end < 0 {
+			c.text(body)
+			break
+		}
+		name, closing, attributes := parseTag(body[1:end])
+		body = body[end+1:]
+
+		switch {
+		case name == "":
+		case hiddenElements[name] && !closing:
+			body = skipElement(body, name)
+		case closing:
+			c.close(name)
+		default:
+			c.open(name, attributes)
+		}
+	}
+
+	// Headings and links left open at the end still keep their text
+	for len(c.frames) > 0 {
+		c.closeFrame()
+	}
+	return finish(c.buffers[0].String())
+}
+
+// buffer collects text. Line breaks and spaces are held back until the next
+// word, so block elements never leave stray blank lines or spaces behind.
+type buffer struct {
+	strings.Builder
+	breaks int
+	space  bool
+}
+
+// frame is an open heading or link; href is only set for links
+type frame struct {
+	name string
+	href string
+}
+
+// converter writes to a stack of buffers; headings and links collect their
+// text in their own buffer so it can be decorated when they close
+type converter struct {
+	buffers []*buffer
+	// frames holds the open headings and links, one per buffer above the
+	// first
+	frames []frame
+	// lists holds the next item number of each open list, 0 for bullets
+	lists []int
+	pre   int
+}
+
+func (c *converter) push() {
+	c.buffers = append(c.buffers, &buffer{})
+}
+
+func (c *converter) pop() string {
+	top := c.buffers[len(c.buffers)-1]
+	c.buffers = c.buffers[:len(c.buffers)-1]
+	return top.String()
+}
+
+func (c *converter) out() *buffer {
+	return c.buffers[len(c.buffers)-1]
+}
+
+// emit writes s after the pending line breaks or space
+func (c *converter) emit(s string) {
+	out := c.out()
+	if out.Len() > 0 {
+		if out.breaks > 0 {
+			out.WriteString(strings.Repeat("\n", out.breaks))
+		} else if out.space {
+			out.WriteByte(' ')
+		}
+	}
+	out.breaks, out.space = 0, false
+	out.WriteString(s)
+}
+
+// text writes character data, collapsing whitespace outside <pre>
+func (c *converter) text(raw string) {
+	if raw == "" {
+		return
+	}
+	text := html.UnescapeString(raw)
+	if c.pre > 0 {
+		c.emit(text)
+		return
+	}
+
+	first, _ := utf8.DecodeRuneInString(text)
+	last, _ := utf8.DecodeLastRuneInString(text)
+	if unicode.IsSpace(first) {
+		c.space()
+	}
+	words := strings.Fields(text)
+	if len(words) > 0 {
+		c.emit(strings.Join(words, " "))
+		if unicode.IsSpace(last) {
+			c.space()
+		}
+	}
+}
+
+func (c *converter) space() {
+	if c.out().breaks == 0 {
+		c.out().space = true
+	}
+}
+
+// newlines asks for at least n line breaks before the next word
+func (c *converter) newlines(n int) {
+	out := c.out()
+	out.breaks = max(out.breaks, n)
+	out.space = false
+}
+
+func (c *converter) open(name string, attributes map[string]string) {
+	switch {
+	case name == "br":
+		c.out().breaks++
+		c.out().space = false
+	case name == "hr":
+		c.newlines(2)
+		c.emit(strings.Repeat("-", 40))
+		c.newlines(2)
+	case name == "h1" || name == "h2":
+		c.newlines(2)
+		c.frames = append(c.frames, frame{name: name})
+		c.push()
+	case name == "a":
+		c.frames = append(c.frames, frame{name: name, href: attributes["href"]})
+		c.push()
+	case name == "img":
+		if alt := strings.TrimSpace(attributes["alt"]); alt != "" {
+			c.text(alt)
+		}
+	case name == "ul" || name == "ol":
+		c.newlines(listBreak(c.lists))
+		next := 0
+		if name == "ol" {
+			next = 1
+			if n, err := strconv.Atoi(attributes["start"]); err == nil {
+				next = n
+			}
+		}
+		c.lists = append(c.lists, next)
+	case name == "li":
+		c.newlines(1)
+		marker := "*"
+		if len(c.lists) > 0 && c.lists[len(c.lists)-1] > 0 {
+			marker = strconv.Itoa(c.lists[len(c.lists)-1]) + "."
+			c.lists[len(c.lists)-1]++
+		}
+		c.emit(strings.Repeat("  ", max(len(c.lists)-1, 0)) + marker)
+		c.space()
+	case name == "td" || name == "th":
+		c.space()
+	case name == "pre":
+		c.newlines(2)
+		c.pre++
+	case paragraphElements[name]:
+		c.newlines(2)
+	case lineElements[name]:
+		c.newlines(1)
+	}
+}
+
+func (c *converter) close(name string) {
+	switch {
+	case name == "h1" || name == "h2" || name == "a":
+		// Headings and links opened inside this one and never closed end
+		// with it; a closing tag without an open element is ignored
+		for i := len(c.frames) - 1; i >= 0; i-- {
+			if c.frames[i].name == name {
+				for len(c.frames) > i {
+					c.closeFrame()
+				}
+				break
+			}
+		}
+	case name == "ul" || name == "ol":
+		if len(c.lists) > 0 {
+			c.lists = c.lists[:len(c.lists)-1]
+		}
+		c.newlines(listBreak(c.lists))
+	case name == "td" || name == "th":
+		c.space()
+	case name == "pre":
+		if c.pre > 0 {
+			c.pre--
+		}
+		c.newlines(2)
+	case paragraphElements[name]:
+		c.newlines(2)
+	case lineElements[name]:
+		c.newlines(1)
+	}
+}
+
+// closeFrame ends the innermost open heading or link, writing its decorated
+// text to the enclosing buffer
+func (c *converter) closeFrame() {
+	f := c.frames[len(c.frames)-1]
+	c.frames = c.frames[:len(c.frames)-1]
+	text := strings.TrimSpace(c.pop())
+
+	if f.name == "a" {
+		c.writeLink(text, f.href)
+		return
+	}
+	if text != "" {
+		underline := "="
+		if f.name == "h2" {
+			underline = "-"
+		}
+		c.emit(text + "\n" + strings.Repeat(underline, utf8.RuneCountInString(text)))
+	}
+	c.newlines(2)
+}
+
+// writeLink writes the link text followed by its URL, leaving out URLs that
+// add nothing: anchors, javascript and URLs equal to the text. The text was
+// already rendered, so it is written as is.
+func (c *converter) writeLink(label, href string) {
+	href = strings.TrimSpace(href)
+	target := strings.TrimPrefix(href, "mailto:")
+	useless := href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:")
+	switch {
+	case label == "":
+		if !useless {
+			c.emit(target)
+		}
+	case useless || label == target || label == href:
+		c.emit(label)
+	case strings.Contains(label, "\n"):
+		// The text holds block content such as a heading, so the URL goes on
+		// its own line
+		c.emit(label)
+		c.newlines(1)
+		c.emit("(" + target + ")")
+	default:
+		c.emit(label)
+		c.space()
+		c.emit("(" + target + ")")
+	}
+}
+
+// listBreak separates top-level lists from the text around them by a blank
+// line and nested lists by a line break
+func listBreak(lists []int) int {
+	if len(lists) == 0 {
+		return 2
+	}
+	return 1
+}
+
+// tagStart returns the index of the first '<' that opens a tag, comment or
+// declaration, or -1. A '<' followed by anything else, as in "a < b", is text.
+func tagStart(s string) int {
+	for i := 0; i < len(s)-1; i++ {
+		if s[i] != '<' {
+			continue
+		}
+		next := s[i+1]
+		if next == '/' || next == '!' || next == '?' || ('a' <= next|0x20 && next|0x20 <= 'z') {
+			return i
+		}
+	}
+	return -1
+}
+
+// tagEnd returns the index of the '>' closing the tag at the start of s,
+// ignoring any inside quoted attribute values
+func tagEnd(s string) int {
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		switch {
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '"' || s[i] == '\'':
+			quote = s[i]
+		case s[i] == '>':
+			return i
+		}
+	}
+	return -1
+}
+
+// parseTag splits the text between '<' and '>' into the lowercased element
+// name, whether it is a closing tag and the attributes
+func parseTag(tag string) (name string, closing bool, attributes map[string]string) {
+	tag = strings.TrimSpace(tag)
+	if strings.HasPrefix(tag, "/") {
+		closing = true
+		tag = tag[1:]
+	}
+	if tag == "" || tag[0] == '!' || tag[0] == '?' {
+		return "", false, nil
+	}
+
+	end := strings.IndexFunc(tag, func(r rune) bool { return unicode.IsSpace(r) || r == '/' })
+	if end < 0 {
+		end = len(tag)
+	}
+	name = strings.ToLower(tag[:end])
+
+	attributes = make(map[string]string)
+	for _, match := range attributePattern.FindAllStringSubmatch(tag[end:], -1) {
+		attributes[strings.ToLower(match[1])] = html.UnescapeString(strings.Trim(match[2], `"'`))
+	}
+	return name, closing, attributes
+}
+
+// skipElement drops everything up to and including the closing tag
+func skipElement(body, name string) string {
+	end := indexFold(body, "</"+name)
+	if end < 0 {
+		return ""
+	}
+	body = body[end:]
+	if close := strings.IndexByte(body, '>'); close >= 0 {
+		return body[close+1:]
+	}
+	return ""
+}
+
+// indexFold returns the index of the first match of the lowercase ASCII
+// needle in s, ignoring ASCII case. Unlike searching strings.ToLower(s), the
+// index is valid in s: lowercasing some runes changes their length in bytes.
+func indexFold(s, needle string) int {
+	for i := 0; i+len(needle) <= len(s); i++ {
+		match := true
+		for j := 0; j < len(needle); j++ {
+			c := s[i+j]
+			if 'A' <= c && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			if c != needle[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
+
+// finish trims trailing whitespace from every line and collapses runs of
+// blank lines
+func finish(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRightFunc(line, unicode.IsSpace)
+	}
+	return strings.TrimSpace(blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")) + "\n"
+}
@@ -0,0 +1,103 @@
+package htmltext
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			name: "paragraphs",
+			html: "<p>Hello</p><p>World</p>",
+			want: "Hello\n\nWorld\n",
+		},
+		{
+			name: "heading",
+			html: "<h1>Welcome</h1><p>Thanks for joining</p>",
+			want: "Welcome\n=======\n\nThanks for joining\n",
+		},
+		{
+			name: "link",
+			html: `<p>Read <a href="https://x.com/terms">the terms</a> first</p>`,
+			want: "Read the terms (https://x.com/terms) first\n",
+		},
+		{
+			name: "link equal to its url",
+			html: `<a href="mailto:a@x.com">a@x.com</a>`,
+			want: "a@x.com\n",
+		},
+		{
+			name: "list",
+			html: "<ol><li>One</li><li>Two</li></ol>",
+			want: "1. One\n2. Two\n",
+		},
+		{
+			name: "hidden elements",
+			html: "<head><title>Ignored</title></head><style>p {}</style><p>Shown</p>",
+			want: "Shown\n",
+		},
+		{
+			name: "unclosed link",
+			html: "<p>Hello <a href='https://x.com'>click here",
+			want: "Hello click here (https://x.com)\n",
+		},
+		{
+			name: "unclosed heading",
+			html: "<h1>Welcome",
+			want: "Welcome\n=======\n",
+		},
+		{
+			name: "unclosed link inside closed heading",
+			html: `<h2><a href="https://x.com">News</h2><p>Body</p>`,
+			want: "News (https://x.com)\n--------------------\n\nBody\n",
+		},
+		{
+			name: "heading inside link",
+			html: `<a href="x">a <h1>b</h1></a>`,
+			want: "a\n\nb\n=\n(x)\n",
+		},
+		{
+			name: "heading inside anchor",
+			html: `<a name="top"><h1>Title</h1></a>`,
+			want: "Title\n=====\n",
+		},
+		{
+			name: "stray closing tags",
+			html: "</a></h1>Text",
+			want: "Text\n",
+		},
+		{
+			name: "bare angle brackets",
+			html: "Price < 5 and > 3 <b>bold</b>",
+			want: "Price < 5 and > 3 bold\n",
+		},
+		{
+			name: "style with runes that shrink when lowercased",
+			html: "<style>/*" + strings.Repeat("İ", 20) + "*/ .a>.b{color:red}</STYLE><p>Hello</p>",
+			want: "Hello\n",
+		},
+		{
+			name: "style with runes that grow when lowercased",
+			html: "<style>" + strings.Repeat("Ⱥ", 20) + "</style>after",
+			want: "after\n",
+		},
+		{
+			name: "escaped angle brackets in link",
+			html: `<a href="https://x.com">&lt;b&gt;</a>`,
+			want: "<b> (https://x.com)\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Convert(tt.html); got != tt.want {
+				t.Errorf("Convert(%q) = %q, want %q", tt.html, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Arturstriker3/api-go/internal/tcp"
+	"github.com/Arturstriker3/api-go/pkg/protocol"
+)
+
+// POST /v1/emails queues one email; the body is the same object as the
+// payload of the "send" operation. An Idempotency-Key header takes precedence
+// over the idempotency_key field.
+func (s *Server) handleSend(sess *tcp.Session, r *http.Request, body []byte) *protocol.Response {
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		var payload map[string]json.RawMessage
+		if err := json.Unmarshal(body, &payload); err == nil && payload != nil {
+			payload["idempotency_key"], _ = json.Marshal(key)
+			body, _ = json.Marshal(payload)
+		}
+	}
+	return s.handler.HandleRequest(sess, newRequest(r, protocol.OpSend, body))
+}
+
+// POST /v1/emails/batch queues several emails: {"items": [...]}
+func (s *Server) handleBatch(sess *tcp.Session, r *http.Request, body []byte) *protocol.Response {
+	return s.handler.HandleRequest(sess, newRequest(r, protocol.OpBatch, body))
+}
+
+// GET /v1/emails/{id} returns the delivery status of a message
+func (s *Server) handleStatus(sess *tcp.Session, r *http.Request, _ []byte) *protocol.Response {
+	payload, _ := json.Marshal(protocol.StatusPayload{MessageID: r.PathValue("id")})
+	return s.handler.HandleRequest(sess, newRequest(r, protocol.OpStatus, payload))
+}
+
+// DELETE /v1/emails/{id} cancels a message still waiting in the queue
+func (s *Server) handleCancel(sess *tcp.Session, r *http.Request, _ []byte) *protocol.Response {
+	payload, _ := json.Marshal(protocol.StatusPayload{MessageID: r.PathValue("id")})
+	return s.handler.HandleRequest(sess, newRequest(r, protocol.OpCancel, payload))
+}
+
+func newRequest(r *http.Request, op string, payload []byte) *protocol.Request {
+	return &protocol.Request{Op: op, RequestID: r.Header.Get("X-Request-ID"), Payload: payload}
+}
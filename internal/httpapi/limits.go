@@ -0,0 +1,46 @@
+package httpapi
+
+import (
+	"log"
+	"net"
+	"sync"
+
+	"github.com/Arturstriker3/api-go/internal/metrics"
+	"github.com/Arturstriker3/api-go/internal/tcp"
+)
+
+// limitListener closes connections over the limiter's caps as soon as they
+// are accepted, before any request is read
+type limitListener struct {
+	net.Listener
+	limiter *tcp.ConnLimiter
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		addr := conn.RemoteAddr().String()
+		if reason := l.limiter.Acquire(addr); reason != "" {
+			metrics.HTTPConnectionsRejected.WithLabelValues(reason).Inc()
+			log.Printf("🟡 Refused HTTP connection from %s: %s reached", addr, reason)
+			conn.Close()
+			continue
+		}
+		return &limitedConn{Conn: conn, release: sync.OnceFunc(func() { l.limiter.Release(addr) })}, nil
+	}
+}
+
+// limitedConn frees its slot once, however many times it is closed
+type limitedConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	c.release()
+	return c.Conn.Close()
+}
@@ -0,0 +1,235 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Arturstriker3/api-go/config"
+	"github.com/Arturstriker3/api-go/internal/metrics"
+	"github.com/Arturstriker3/api-go/internal/tcp"
+	"github.com/Arturstriker3/api-go/pkg/protocol"
+)
+
+// Server exposes the protocol operations over HTTP/JSON. Every request is
+// authenticated and executed by the same Handler as the TCP server, so both
+// entry points share validation, scopes, bans and metrics.
+type Server struct {
+	config  *config.Config
+	handler *tcp.Handler
+	server  *http.Server
+	limiter *tcp.ConnLimiter
+}
+
+// NewServer creates the HTTP API server. tlsConfig is required when HTTP_TLS_ENABLED
+// is set and is normally the TCP server's, so both present the same certificate.
+func NewServer(cfg *config.Config, handler *tcp.Handler, tlsConfig *tls.Config) *Server {
+	s := &Server{
+		config:  cfg,
+		handler: handler,
+		limiter: tcp.NewConnLimiter(cfg.HTTP.MaxConnections, cfg.HTTP.MaxConnectionsPerIP),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/emails", s.route("send", s.handleSend))
+	mux.HandleFunc("POST /v1/emails/batch", s.route("batch", s.handleBatch))
+	mux.HandleFunc("GET /v1/emails/{id}", s.route("status", s.handleStatus))
+	mux.HandleFunc("DELETE /v1/emails/{id}", s.route("cancel", s.handleCancel))
+
+	s.server = &http.Server{
+		Addr:              cfg.HTTP.Address,
+		Handler:           mux,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: cfg.TCP.HandshakeTimeout,
+		ReadTimeout:       cfg.HTTP.ReadTimeout,
+		WriteTimeout:      cfg.TCP.WriteTimeout,
+		IdleTimeout:       cfg.TCP.IdleTimeout,
+	}
+	return s
+}
+
+// Start serves the API until Shutdown is called
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.config.HTTP.Address)
+	if err != nil {
+		return fmt.Errorf("failed to start HTTP API: %w", err)
+	}
+	listener = &limitListener{Listener: listener, limiter: s.limiter}
+
+	if s.config.HTTP.TLS {
+		log.Printf("🔒 HTTP API listening on %s (SECURE)", s.config.HTTP.Address)
+		// Certificates come from the shared TLS config
+		err = s.server.ServeTLS(listener, "", "")
+	} else {
+		log.Printf("🟡 HTTP API listening on %s (INSECURE)", s.config.HTTP.Address)
+		err = s.server.Serve(listener)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to start HTTP API: %w", err)
+	}
+	return nil
+}
+
+// Shutdown stops accepting requests and waits for those in flight
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// apiFunc executes one request for an authenticated session and returns the
+// protocol response to translate into HTTP
+type apiFunc func(sess *tcp.Session, r *http.Request, body []byte) *protocol.Response
+
+// route wraps an endpoint with body limits, authentication, the JSON
+// response encoding and metrics
+func (s *Server) route(name string, fn apiFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		response := s.serve(w, r, fn)
+		code := s.writeResponse(w, r, name, response)
+
+		metrics.HTTPRequests.WithLabelValues(name, strconv.Itoa(code)).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	}
+}
+
+// serve authenticates from the headers before reading the body, so clients
+// without credentials cannot make the server buffer large bodies
+func (s *Server) serve(w http.ResponseWriter, r *http.Request, fn apiFunc) *protocol.Response {
+	sess, failure := s.authenticate(r)
+	if failure != nil {
+		return failure
+	}
+
+	maxBodySize := s.config.TCP.MaxFrameSize
+	if maxBodySize <= 0 {
+		maxBodySize = protocol.DefaultMaxFrameSize
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBodySize)))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return protocol.NewError(nil, protocol.CodeFrameTooLarge, fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit))
+		}
+		return protocol.NewError(nil, protocol.CodeInvalidRequest, "Failed to read request body")
+	}
+	return fn(sess, r, body)
+}
+
+// authenticate runs the bearer token through the protocol auth operation.
+// A client certificate mapped to a key in identity mode is enough on its own.
+func (s *Server) authenticate(r *http.Request) (*tcp.Session, *protocol.Response) {
+	if !s.handler.AllowConnection(r.RemoteAddr) {
+		return nil, protocol.NewError(nil, protocol.CodeForbidden, "Too many failed authentications from this address")
+	}
+
+	sess := tcp.NewSession(r.RemoteAddr, r.TLS != nil)
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		s.handler.AuthenticateCertificate(sess, r.TLS.PeerCertificates[0])
+	}
+
+	token, ok := bearerToken(r)
+	if !ok {
+		if sess.Authenticated {
+			return sess, nil
+		}
+		return nil, protocol.NewError(nil, protocol.CodeAuthRequired, "Missing bearer token")
+	}
+
+	// Without a key ID the secret would be hashed against every key
+	keyID := r.Header.Get("X-Key-ID")
+	if keyID == "" {
+		keyID = sess.CertKeyID
+	}
+	if keyID == "" {
+		return nil, protocol.NewError(nil, protocol.CodeAuthRequired, "Missing X-Key-ID header")
+	}
+
+	payload, _ := json.Marshal(protocol.AuthPayload{KeyID: keyID, Secret: token})
+	response := s.handler.HandleRequest(sess, &protocol.Request{Op: protocol.OpAuth, Payload: payload})
+	if !response.OK {
+		return nil, response
+	}
+	return sess, nil
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// errorBody is the JSON body of every failed request
+type errorBody struct {
+	Error *protocol.Error `json:"error"`
+}
+
+// writeResponse encodes the result, or the error with a matching status code,
+// and returns the status code written
+func (s *Server) writeResponse(w http.ResponseWriter, r *http.Request, route string, response *protocol.Response) int {
+	w.Header().Set("Content-Type", "application/json")
+	if requestID := r.Header.Get("X-Request-ID"); requestID != "" {
+		w.Header().Set("X-Request-ID", requestID)
+	}
+
+	if !response.OK {
+		code := statusCode(response.Error.Code)
+		if code == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gomailer"`)
+		}
+		if response.Error.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(response.Error.RetryAfter))
+		}
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(errorBody{Error: response.Error})
+		return code
+	}
+
+	code := http.StatusOK
+	if route == "send" {
+		code = http.StatusAccepted
+
+		var result protocol.SendResult
+		if response.DecodeResult(&result) == nil && result.Replayed {
+			w.Header().Set("Idempotent-Replayed", "true")
+		}
+	}
+	w.WriteHeader(code)
+	w.Write(response.Result)
+	return code
+}
+
+// statusCode maps a protocol error code to an HTTP status
+func statusCode(code string) int {
+	switch code {
+	case protocol.CodeAuthRequired, protocol.CodeAuthFailed, protocol.CodeHMACRequired:
+		return http.StatusUnauthorized
+	case protocol.CodeForbidden:
+		return http.StatusForbidden
+	case protocol.CodeNotFound, protocol.CodeUnknownOp:
+		return http.StatusNotFound
+	case protocol.CodeNotCancellable:
+		return http.StatusConflict
+	case protocol.CodeIdempotencyConflict:
+		return http.StatusUnprocessableEntity
+	case protocol.CodeFrameTooLarge, protocol.CodeAttachmentTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case protocol.CodeQueueFailed, protocol.CodeBusy:
+		return http.StatusServiceUnavailable
+	case protocol.CodeInternal:
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
@@ -0,0 +1,112 @@
+package inbound
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Arturstriker3/api-go/config"
+	"github.com/Arturstriker3/api-go/internal/mimeparse"
+	"github.com/Arturstriker3/api-go/internal/smtpd"
+	"github.com/Arturstriker3/api-go/internal/tcp"
+)
+
+// Backend receives mail for the configured domains and forwards it as events.
+// A message is only acknowledged once every sink has it, so when forwarding
+// fails the sending server keeps the message and retries later.
+type Backend struct {
+	domains []string
+	sinks   []Sink
+
+	// ctx is cancelled on Close to stop webhook retries in progress
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewBackend creates the sinks configured in cfg.Inbound
+func NewBackend(cfg *config.Config) (*Backend, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	backend := &Backend{
+		domains: cfg.Inbound.Domains,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	if cfg.Inbound.WebhookURL != "" {
+		backend.sinks = append(backend.sinks, NewWebhookSink(
+			cfg.Inbound.WebhookURL,
+			cfg.Inbound.WebhookSecret,
+			cfg.Inbound.WebhookRetries,
+			cfg.Inbound.WebhookTimeout,
+		))
+	}
+	if cfg.Inbound.Exchange != "" {
+		exchange, err := NewExchangeSink(cfg)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		backend.sinks = append(backend.sinks, exchange)
+	}
+	return backend, nil
+}
+
+func (b *Backend) Name() string {
+	return "inbound"
+}
+
+// RequireAuth is false: inbound mail comes from any MTA on the internet
+func (b *Backend) RequireAuth() bool {
+	return false
+}
+
+// Recipient only accepts addresses in the configured domains, so the
+// listener can never be used as an open relay
+func (b *Backend) Recipient(address string) error {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return &smtpd.Error{Code: 550, Message: "5.1.1 Mailbox unavailable"}
+	}
+	domain := strings.ToLower(address[at+1:])
+	for _, allowed := range b.domains {
+		if domain == allowed {
+			return nil
+		}
+	}
+	return &smtpd.Error{Code: 550, Message: "5.7.1 Relaying denied"}
+}
+
+func (b *Backend) Deliver(sess *tcp.Session, from string, to []string, raw []byte) (string, error) {
+	msg, err := mimeparse.Parse(raw)
+	if err != nil {
+		return "", &smtpd.Error{Code: 554, Message: "5.6.0 " + err.Error()}
+	}
+
+	event := NewEvent(raw, msg, sess.RemoteAddr, from, to)
+	body, err := json.Marshal(event)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	for _, sink := range b.sinks {
+		if err := sink.Publish(b.ctx, event, body); err != nil {
+			return "", fmt.Errorf("%s: %w", sink.Name(), err)
+		}
+	}
+
+	log.Printf("📥 Inbound email from %s to %v forwarded as %s", from, to, event.ID)
+	return event.ID, nil
+}
+
+// Close abandons deliveries still retrying and closes the sinks. Their
+// messages were not acknowledged, so the sending servers retry them.
+func (b *Backend) Close() {
+	b.cancel()
+	for _, sink := range b.sinks {
+		if closer, ok := sink.(interface{ Close() }); ok {
+			closer.Close()
+		}
+	}
+}
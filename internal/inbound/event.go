@@ -0,0 +1,146 @@
+package inbound
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/Arturstriker3/api-go/internal/email"
+	"github.com/Arturstriker3/api-go/internal/mimeparse"
+)
+
+// EventType is the type of every event published for received mail
+const EventType = "email.received"
+
+// Event is the JSON document forwarded for every received message
+type Event struct {
+	// ID is derived from the raw message, so a message the sending server
+	// retries after a failed delivery keeps the same ID
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	ReceivedAt time.Time `json:"received_at"`
+	RemoteAddr string    `json:"remote_addr"`
+	Envelope   Envelope  `json:"envelope"`
+
+	From      string   `json:"from"`
+	To        []string `json:"to"`
+	Cc        []string `json:"cc,omitempty"`
+	ReplyTo   []string `json:"reply_to,omitempty"`
+	Subject   string   `json:"subject"`
+	MessageID string   `json:"message_id,omitempty"`
+	Thread    Thread   `json:"thread"`
+
+	Headers     map[string][]string `json:"headers"`
+	Text        string              `json:"text,omitempty"`
+	HTML        string              `json:"html,omitempty"`
+	Attachments []Attachment        `json:"attachments"`
+	// UnknownCharsets flags bodies whose charset could not be decoded to
+	// UTF-8; their invalid bytes were replaced by U+FFFD
+	UnknownCharsets []string `json:"unknown_charsets,omitempty"`
+}
+
+// Envelope holds the SMTP MAIL FROM and RCPT TO addresses, which may differ
+// from the From and To headers
+type Envelope struct {
+	From string   `json:"from"`
+	To   []string `json:"to"`
+}
+
+// Thread links a reply to the message it answers
+type Thread struct {
+	InReplyTo  string   `json:"in_reply_to,omitempty"`
+	References []string `json:"references,omitempty"`
+	// GoMailerMessageID is the ID returned by send when the reply answers
+	// an email sent through GoMailer
+	GoMailerMessageID string `json:"gomailer_message_id,omitempty"`
+}
+
+// Attachment content is base64 encoded in JSON
+type Attachment struct {
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"content_type"`
+	ContentID   string `json:"content_id,omitempty"`
+	Disposition string `json:"disposition"`
+	Size        int    `json:"size"`
+	Content     []byte `json:"content"`
+}
+
+// NewEvent builds the event for a parsed message
+func NewEvent(raw []byte, msg *mimeparse.Message, remoteAddr, from string, to []string) *Event {
+	sum := sha256.Sum256(raw)
+
+	event := &Event{
+		ID:         hex.EncodeToString(sum[:16]),
+		Type:       EventType,
+		ReceivedAt: time.Now().UTC(),
+		RemoteAddr: remoteAddr,
+		Envelope:   Envelope{From: from, To: to},
+		To:         addressList(msg.Header, "To"),
+		Cc:         addressList(msg.Header, "Cc"),
+		ReplyTo:    addressList(msg.Header, "Reply-To"),
+		Subject:    msg.Subject,
+		MessageID:  strings.TrimSpace(msg.Header.Get("Message-ID")),
+		Thread: Thread{
+			InReplyTo:  strings.TrimSpace(msg.Header.Get("In-Reply-To")),
+			References: strings.Fields(msg.Header.Get("References")),
+		},
+		Headers:     msg.Header,
+		Text:        msg.Text,
+		HTML:        msg.HTML,
+		Attachments: make([]Attachment, 0, len(msg.Attachments)),
+
+		UnknownCharsets: msg.UnknownCharsets,
+	}
+	if from := addressList(msg.Header, "From"); len(from) > 0 {
+		event.From = from[0]
+	}
+
+	// In-Reply-To names the direct parent; fall back to the newest
+	// reference for clients that only set References
+	candidates := append([]string{event.Thread.InReplyTo}, reverse(event.Thread.References)...)
+	for _, candidate := range candidates {
+		if id, ok := email.MessageIDFromHeader(candidate); ok {
+			event.Thread.GoMailerMessageID = id
+			break
+		}
+	}
+
+	for _, attachment := range msg.Attachments {
+		event.Attachments = append(event.Attachments, Attachment{
+			Filename:    attachment.Filename,
+			ContentType: attachment.ContentType,
+			ContentID:   attachment.ContentID,
+			Disposition: attachment.Disposition,
+			Size:        len(attachment.Data),
+			Content:     attachment.Data,
+		})
+	}
+	return event
+}
+
+// addressList returns the bare addresses of an address header, or the raw
+// value when it cannot be parsed
+func addressList(header mail.Header, key string) []string {
+	if header.Get(key) == "" {
+		return nil
+	}
+	list, err := header.AddressList(key)
+	if err != nil {
+		return []string{header.Get(key)}
+	}
+	addresses := make([]string, 0, len(list))
+	for _, address := range list {
+		addresses = append(addresses, address.Address)
+	}
+	return addresses
+}
+
+func reverse(values []string) []string {
+	reversed := make([]string, len(values))
+	for i, value := range values {
+		reversed[len(values)-1-i] = value
+	}
+	return reversed
+}
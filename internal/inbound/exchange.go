@@ -0,0 +1,113 @@
+package inbound
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/Arturstriker3/api-go/config"
+	"github.com/Arturstriker3/api-go/internal/metrics"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// RoutingKey is the routing key events are published with
+const RoutingKey = "inbound.email"
+
+// ExchangeSink publishes every event to a durable RabbitMQ topic exchange and
+// waits for the broker to confirm it
+type ExchangeSink struct {
+	exchange string
+	conn     *amqp.Connection
+	channel  *amqp.Channel
+	// mu keeps each publish paired with its confirmation
+	mu sync.Mutex
+}
+
+func NewExchangeSink(cfg *config.Config) (*ExchangeSink, error) {
+	conn, err := amqp.Dial(fmt.Sprintf("amqp://%s:%s@%s:%s/",
+		cfg.RabbitMQ.User,
+		cfg.RabbitMQ.Password,
+		cfg.RabbitMQ.Host,
+		cfg.RabbitMQ.Port,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+
+	err = ch.ExchangeDeclare(
+		cfg.Inbound.Exchange, // name
+		"topic",              // kind
+		true,                 // durable
+		false,                // auto-delete
+		false,                // internal
+		false,                // no-wait
+		nil,                  // arguments
+	)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to declare exchange %q: %w", cfg.Inbound.Exchange, err)
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+
+	return &ExchangeSink{
+		exchange: cfg.Inbound.Exchange,
+		conn:     conn,
+		channel:  ch,
+	}, nil
+}
+
+func (e *ExchangeSink) Name() string {
+	return "exchange"
+}
+
+func (e *ExchangeSink) Publish(ctx context.Context, event *Event, body []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	confirmation, err := e.channel.PublishWithDeferredConfirmWithContext(ctx,
+		e.exchange, // exchange
+		RoutingKey, // routing key
+		false,      // mandatory
+		false,      // immediate
+		amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			MessageId:    event.ID,
+			Type:         event.Type,
+			Timestamp:    event.ReceivedAt,
+			Body:         body,
+		},
+	)
+	if err == nil {
+		var acked bool
+		if acked, err = confirmation.WaitContext(ctx); err == nil && !acked {
+			err = fmt.Errorf("broker rejected the event")
+		}
+	}
+	if err != nil {
+		metrics.InboundDeliveries.WithLabelValues("exchange", "failure").Inc()
+		return fmt.Errorf("failed to publish to exchange %q: %w", e.exchange, err)
+	}
+
+	metrics.InboundDeliveries.WithLabelValues("exchange", "success").Inc()
+	return nil
+}
+
+func (e *ExchangeSink) Close() {
+	if e.channel != nil {
+		e.channel.Close()
+	}
+	if e.conn != nil {
+		e.conn.Close()
+	}
+}
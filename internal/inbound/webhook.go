@@ -0,0 +1,113 @@
+package inbound
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Arturstriker3/api-go/internal/metrics"
+)
+
+// SignatureHeader carries "t=<unix time>,v1=<hex HMAC-SHA256>" where the
+// HMAC covers "<unix time>.<request body>"
+const SignatureHeader = "X-GoMailer-Signature"
+
+// Sink is a destination inbound events are forwarded to
+type Sink interface {
+	Name() string
+	Publish(ctx context.Context, event *Event, body []byte) error
+}
+
+// WebhookSink POSTs every event to a URL, retrying with exponential backoff
+// while the receiver is unavailable
+type WebhookSink struct {
+	url     string
+	secret  []byte
+	retries int
+	client  *http.Client
+}
+
+func NewWebhookSink(url, secret string, retries int, timeout time.Duration) *WebhookSink {
+	return &WebhookSink{
+		url:     url,
+		secret:  []byte(secret),
+		retries: retries,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+func (w *WebhookSink) Name() string {
+	return "webhook"
+}
+
+// Publish delivers the event, making up to retries extra attempts. Network
+// errors, 429 and 5xx responses are retried; other responses are final.
+func (w *WebhookSink) Publish(ctx context.Context, event *Event, body []byte) error {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(ctx, event, body)
+		if err == nil {
+			metrics.InboundDeliveries.WithLabelValues("webhook", "success").Inc()
+			return nil
+		}
+		if !retry || attempt >= w.retries {
+			metrics.InboundDeliveries.WithLabelValues("webhook", "failure").Inc()
+			return fmt.Errorf("webhook delivery failed after %d attempt(s): %w", attempt+1, err)
+		}
+
+		metrics.InboundDeliveries.WithLabelValues("webhook", "retry").Inc()
+		log.Printf("🟡 Webhook delivery of inbound event %s failed, retrying in %s: %v", event.ID, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// post makes one attempt and reports whether a failure is worth retrying
+func (w *WebhookSink) post(ctx context.Context, event *Event, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "GoMailer-Inbound")
+	req.Header.Set("X-GoMailer-Event-ID", event.ID)
+	req.Header.Set(SignatureHeader, "t="+timestamp+",v1="+Sign(w.secret, timestamp, body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook responded %s", resp.Status)
+	default:
+		return false, fmt.Errorf("webhook responded %s", resp.Status)
+	}
+}
+
+// Sign computes the v1 signature of a webhook request
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -62,6 +62,21 @@ var (
 		Help: "Total number of failed TCP authentications (plain)",
 	})
 
+	TCPConnectionsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomailer_tcp_connections_rejected_total",
+		Help: "Total number of connections refused by reason (max_connections, max_connections_per_ip, proxy_header)",
+	}, []string{"reason"})
+
+	TCPConnectionsOpen = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gomailer_tcp_connections_open",
+		Help: "Current number of open connections on every listener, counted against TCP_MAX_CONNECTIONS",
+	})
+
+	TCPTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomailer_tcp_timeouts_total",
+		Help: "Total number of connections closed by a deadline (handshake, auth, idle, write)",
+	}, []string{"stage"})
+
 	// TLS metrics (secure)
 	TLSConnections = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "gomailer_tls_connections_current",
@@ -72,4 +87,116 @@ var (
 		Name: "gomailer_tls_certificate_expiry_days",
 		Help: "Days until TLS certificate expires",
 	})
+
+	TLSClientCertAuth = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomailer_tls_client_cert_auth_total",
+		Help: "Total number of verified client certificates by outcome (authenticated, mapped, unmapped)",
+	}, []string{"result"})
+
+	TLSAuthSuccess = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gomailer_tls_auth_success_total",
+		Help: "Total number of successful TLS authentications (secure)",
+	})
+
+	TLSAuthErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gomailer_tls_auth_errors_total",
+		Help: "Total number of failed TLS authentications (secure)",
+	})
+
+	// Brute-force protection metrics
+	AuthBans = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gomailer_auth_bans_total",
+		Help: "Total number of temporary bans issued after repeated authentication failures",
+	})
+
+	AuthBansActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gomailer_auth_bans_active",
+		Help: "Current number of banned client addresses",
+	})
+
+	AuthRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomailer_auth_rejected_total",
+		Help: "Total number of connections or logins refused by reason (banned, denylisted)",
+	}, []string{"reason"})
+
+	UnixPeerAuth = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomailer_unix_peer_auth_total",
+		Help: "Unix socket connections by peer credential outcome (authenticated, unmapped)",
+	}, []string{"result"})
+
+	// HTTP API metrics
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomailer_http_requests_total",
+		Help: "Total number of HTTP API requests by route and status code",
+	}, []string{"route", "code"})
+
+	HTTPConnectionsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomailer_http_connections_rejected_total",
+		Help: "Total number of HTTP API connections refused by reason (max_connections, max_connections_per_ip)",
+	}, []string{"reason"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gomailer_http_request_duration_seconds",
+		Help:    "Time taken to answer HTTP API requests by route",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route"})
+
+	// SMTP listener metrics, by listener (submission, inbound)
+	SMTPDConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gomailer_smtpd_connections_current",
+		Help: "Current number of SMTP connections by listener",
+	}, []string{"listener"})
+
+	SMTPDConnectionsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomailer_smtpd_connections_rejected_total",
+		Help: "Total number of SMTP connections refused by listener and reason (max_connections, max_connections_per_ip)",
+	}, []string{"listener", "reason"})
+
+	SMTPDMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomailer_smtpd_messages_total",
+		Help: "Total number of messages received over SMTP by listener and outcome (accepted, rejected)",
+	}, []string{"listener", "result"})
+
+	// Inbound forwarding metrics
+	InboundDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomailer_inbound_deliveries_total",
+		Help: "Total number of inbound email events forwarded by sink (webhook, exchange) and outcome (success, retry, failure)",
+	}, []string{"sink", "result"})
+
+	IdempotentReplays = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gomailer_idempotent_replays_total",
+		Help: "Total number of sends answered from an earlier request with the same idempotency key",
+	})
+
+	DuplicateDeliveriesSuppressed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gomailer_duplicate_deliveries_suppressed_total",
+		Help: "Total number of redelivered emails skipped because they had already been sent",
+	})
+
+	Backpressure = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomailer_backpressure_total",
+		Help: "Total number of sends slowed down (delayed) or refused (rejected) because the queue backlog was too deep",
+	}, []string{"action"})
+
+	BackpressureDelay = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gomailer_backpressure_delay_seconds",
+		Help:    "Time sends were held back because the queue backlog passed BACKPRESSURE_SOFT_LIMIT",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10},
+	})
+
+	// Per API key metrics
+	RequestsByKey = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomailer_requests_total",
+		Help: "Total number of protocol requests by API key, operation and outcome",
+	}, []string{"key_id", "op", "status"})
+
+	AuthFailuresByKey = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomailer_auth_failures_total",
+		Help: "Total number of failed authentications by API key (\"unknown\" when no key matched)",
+	}, []string{"key_id", "reason"})
+
+	EmailsQueuedByKey = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gomailer_emails_queued_by_key_total",
+		Help: "Total number of emails queued by API key",
+	}, []string{"key_id"})
 ) 
\ No newline at end of file
@@ -0,0 +1,170 @@
+package mimeparse
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// Message is a received RFC 5322 message split into its bodies and
+// attachments
+type Message struct {
+	Header mail.Header
+	// Subject is decoded from RFC 2047 encoded-words
+	Subject     string
+	Text        string
+	HTML        string
+	Attachments []Attachment
+	// UnknownCharsets lists the charsets of body parts that could not be
+	// decoded; those bodies are kept with invalid UTF-8 replaced by U+FFFD
+	UnknownCharsets []string
+}
+
+// Attachment is any part that is not the message body, including inline
+// images referenced by Content-ID
+type Attachment struct {
+	Filename    string
+	ContentType string
+	ContentID   string
+	// Disposition is "attachment" or "inline"
+	Disposition string
+	Data        []byte
+}
+
+// decoder also understands the charsets of the WHATWG encoding index in
+// RFC 2047 encoded-words, not just UTF-8 and ISO-8859-1
+var decoder = &mime.WordDecoder{
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		encoding, err := htmlindex.Get(charset)
+		if err != nil {
+			return nil, fmt.Errorf("unknown charset %q", charset)
+		}
+		return encoding.NewDecoder().Reader(input), nil
+	},
+}
+
+// Parse reads a message, descending into multipart containers. The first
+// text/plain and text/html parts not marked as attachments are the bodies;
+// every other leaf part is an attachment.
+func Parse(raw []byte) (*Message, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("malformed message: %w", err)
+	}
+
+	result := &Message{
+		Header:  msg.Header,
+		Subject: DecodeHeader(msg.Header.Get("Subject")),
+	}
+	if err := result.walk(textproto.MIMEHeader(msg.Header), msg.Body); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DecodeHeader decodes RFC 2047 encoded-words, returning the value unchanged
+// when it is not valid
+func DecodeHeader(value string) string {
+	decoded, err := decoder.DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+func (m *Message) walk(header textproto.MIMEHeader, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		// RFC 2045: a missing or invalid Content-Type means plain text
+		mediaType, params = "text/plain", nil
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("malformed multipart body: %w", err)
+			}
+			if err := m.walk(part.Header, part); err != nil {
+				return err
+			}
+		}
+	}
+
+	content, err := io.ReadAll(decodeTransfer(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return fmt.Errorf("failed to decode part: %w", err)
+	}
+
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	if disposition != "attachment" {
+		switch {
+		case mediaType == "text/html" && m.HTML == "":
+			m.HTML = m.decodeText(params["charset"], content)
+			return nil
+		case mediaType == "text/plain" && m.Text == "":
+			m.Text = m.decodeText(params["charset"], content)
+			return nil
+		}
+	}
+
+	filename := dispositionParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	m.Attachments = append(m.Attachments, Attachment{
+		Filename:    DecodeHeader(filename),
+		ContentType: mediaType,
+		ContentID:   strings.Trim(header.Get("Content-ID"), "<> "),
+		Disposition: disposition,
+		Data:        content,
+	})
+	return nil
+}
+
+// decodeText converts a body part to UTF-8 from its charset. A missing
+// charset is read as UTF-8, which covers US-ASCII and most unlabelled 8-bit
+// mail; unknown charsets are recorded in UnknownCharsets.
+func (m *Message) decodeText(charset string, content []byte) string {
+	charset = strings.ToLower(strings.TrimSpace(charset))
+	if charset == "" || charset == "utf-8" || charset == "us-ascii" {
+		return strings.ToValidUTF8(string(content), "\uFFFD")
+	}
+
+	encoding, err := htmlindex.Get(charset)
+	if err == nil {
+		var decoded []byte
+		if decoded, err = encoding.NewDecoder().Bytes(content); err == nil {
+			return string(decoded)
+		}
+	}
+	m.UnknownCharsets = append(m.UnknownCharsets, charset)
+	return strings.ToValidUTF8(string(content), "\uFFFD")
+}
+
+func decodeTransfer(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	default:
+		return body
+	}
+}
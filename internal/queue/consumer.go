@@ -1,9 +1,11 @@
 package queue
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
 	"github.com/Arturstriker3/api-go/config"
@@ -16,6 +18,16 @@ type Consumer struct {
 	conn         *amqp.Connection
 	channel      *amqp.Channel
 	emailService *email.Service
+	// sent records delivered message IDs so redeliveries are not sent
+	// twice; nil when deduplication is disabled
+	sent *SentLog
+
+	// tag identifies this consumer so it can be cancelled on shutdown
+	tag string
+	// done is closed once the delivery loop has finished its last message
+	done chan struct{}
+	// stopPolling ends the queue size poller
+	stopPolling chan struct{}
 }
 
 func NewConsumer(cfg *config.Config, emailService *email.Service) (*Consumer, error) {
@@ -36,10 +48,25 @@ func NewConsumer(cfg *config.Config, emailService *email.Service) (*Consumer, er
 		return nil, fmt.Errorf("failed to open channel: %w", err)
 	}
 
+	var sent *SentLog
+	if cfg.Dedupe.Path != "" {
+		if sent, err = OpenSentLog(cfg.Dedupe.Path, cfg.Dedupe.Retention); err != nil {
+			ch.Close()
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	hostname, _ := os.Hostname()
+
 	return &Consumer{
 		conn:         conn,
 		channel:      ch,
 		emailService: emailService,
+		sent:         sent,
+		tag:          fmt.Sprintf("gomailer-%s-%d", hostname, os.Getpid()),
+		done:         make(chan struct{}),
+		stopPolling:  make(chan struct{}),
 	}, nil
 }
 
@@ -57,8 +84,7 @@ func (c *Consumer) Setup() error {
 		return fmt.Errorf("failed to declare queue: %w", err)
 	}
 
-	// Update queue size metric
-	metrics.QueueSize.Set(float64(queue.Messages))
+	c.setQueueSize(queue.Messages)
 
 	// Set QoS
 	err = c.channel.Qos(
@@ -76,7 +102,7 @@ func (c *Consumer) Setup() error {
 func (c *Consumer) StartConsuming() error {
 	msgs, err := c.channel.Consume(
 		"email_queue", // queue
-		"",           // consumer
+		c.tag,        // consumer
 		false,        // auto-ack
 		false,        // exclusive
 		false,        // no-local
@@ -88,6 +114,8 @@ func (c *Consumer) StartConsuming() error {
 	}
 
 	go func() {
+		defer close(c.done)
+
 		for msg := range msgs {
 			start := time.Now()
 
@@ -98,6 +126,25 @@ func (c *Consumer) StartConsuming() error {
 				metrics.EmailErrors.Inc()
 				continue
 			}
+			if emailData.MessageID == "" {
+				emailData.MessageID = msg.MessageId
+			}
+
+			if c.emailService.Statuses().IsCancelled(emailData.MessageID) {
+				log.Printf("🟡 Skipping cancelled message %s", emailData.MessageID)
+				msg.Ack(false)
+				continue
+			}
+
+			// The previous attempt may have sent the email and died before
+			// the ack reached RabbitMQ
+			if msg.Redelivered && c.sent != nil && c.sent.Contains(emailData.MessageID) {
+				log.Printf("🔁 Skipping redelivered message %s, already sent", emailData.MessageID)
+				c.emailService.Statuses().Record(emailData.MessageID, email.StateSent, "")
+				metrics.DuplicateDeliveriesSuppressed.Inc()
+				msg.Ack(false)
+				continue
+			}
 
 			if err := c.emailService.SendEmail(&emailData); err != nil {
 				log.Printf("Error sending email: %v", err)
@@ -106,6 +153,14 @@ func (c *Consumer) StartConsuming() error {
 				continue
 			}
 
+			// Record the send before acking; if that fails the email still
+			// went out, so it is acked anyway rather than sent again
+			if c.sent != nil {
+				if err := c.sent.Record(emailData.MessageID); err != nil {
+					log.Printf("🔴 Failed to record sent message %s: %v", emailData.MessageID, err)
+				}
+			}
+
 			msg.Ack(false)
 			metrics.EmailsSent.Inc()
 			metrics.QueueLatency.Observe(time.Since(start).Seconds())
@@ -113,7 +168,7 @@ func (c *Consumer) StartConsuming() error {
 			// Update queue size after processing
 			queue, err := c.channel.QueueInspect("email_queue")
 			if err == nil {
-				metrics.QueueSize.Set(float64(queue.Messages))
+				c.setQueueSize(queue.Messages)
 			}
 		}
 	}()
@@ -123,10 +178,16 @@ func (c *Consumer) StartConsuming() error {
 		ticker := time.NewTicker(5 * time.Second)
 		defer ticker.Stop()
 
-		for range ticker.C {
+		for {
+			select {
+			case <-ticker.C:
+			case <-c.stopPolling:
+				return
+			}
+
 			queue, err := c.channel.QueueInspect("email_queue")
 			if err == nil {
-				metrics.QueueSize.Set(float64(queue.Messages))
+				c.setQueueSize(queue.Messages)
 			}
 		}
 	}()
@@ -134,6 +195,35 @@ func (c *Consumer) StartConsuming() error {
 	return nil
 }
 
+// setQueueSize publishes the queue backlog to the metrics and to the email
+// service, which refuses new emails while it is too deep
+func (c *Consumer) setQueueSize(messages int) {
+	metrics.QueueSize.Set(float64(messages))
+	c.emailService.SetBacklog(messages)
+}
+
+// Shutdown stops receiving new deliveries and waits for the message being
+// sent to be acknowledged. If ctx expires first the unacknowledged message
+// goes back to the queue once the channel is closed.
+func (c *Consumer) Shutdown(ctx context.Context) error {
+	close(c.stopPolling)
+
+	// RabbitMQ stops delivering and closes the deliveries channel once the
+	// in-flight message (prefetch is 1) has been handled
+	if err := c.channel.Cancel(c.tag, false); err != nil {
+		return fmt.Errorf("failed to cancel consumer: %w", err)
+	}
+
+	select {
+	case <-c.done:
+		log.Println("✅ Consumer drained")
+		return nil
+	case <-ctx.Done():
+		log.Println("🔴 Timed out waiting for the current email to be sent; it will be redelivered")
+		return ctx.Err()
+	}
+}
+
 func (c *Consumer) Close() {
 	if c.channel != nil {
 		c.channel.Close()
@@ -141,4 +231,7 @@ func (c *Consumer) Close() {
 	if c.conn != nil {
 		c.conn.Close()
 	}
+	if c.sent != nil {
+		c.sent.Close()
+	}
 } 
\ No newline at end of file
@@ -0,0 +1,187 @@
+package queue
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SentLog is a durable record of the message IDs this instance has sent. The
+// consumer writes each ID before acking its delivery, so a message RabbitMQ
+// redelivers after a crash between the send and the ack can be recognised
+// and skipped.
+//
+// The file holds one "<message id> <unix time>" line per sent message and is
+// rewritten without the expired entries when opened and once they make up
+// most of it.
+type SentLog struct {
+	mu        sync.Mutex
+	path      string
+	file      *os.File
+	retention time.Duration
+	sent      map[string]time.Time
+	// lines counts the entries in the file, expired or not
+	lines     int
+	lastPrune time.Time
+}
+
+// OpenSentLog loads the IDs recorded within the retention period and opens
+// the file for appending, creating it and its directory if needed
+func OpenSentLog(path string, retention time.Duration) (*SentLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create sent log directory: %w", err)
+	}
+
+	l := &SentLog{
+		path:      path,
+		retention: retention,
+		sent:      make(map[string]time.Time),
+	}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	if err := l.compact(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *SentLog) load() error {
+	file, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open sent log: %w", err)
+	}
+	defer file.Close()
+
+	cutoff := time.Now().Add(-l.retention)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// A line cut short by a crash is ignored
+		id, unix, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || id == "" {
+			continue
+		}
+		seconds, err := strconv.ParseInt(unix, 10, 64)
+		if err != nil {
+			continue
+		}
+		if sentAt := time.Unix(seconds, 0); sentAt.After(cutoff) {
+			l.sent[id] = sentAt
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read sent log: %w", err)
+	}
+	return nil
+}
+
+// prune forgets expired IDs
+func (l *SentLog) prune(now time.Time) {
+	l.lastPrune = now
+	cutoff := now.Add(-l.retention)
+	for id, sentAt := range l.sent {
+		if !sentAt.After(cutoff) {
+			delete(l.sent, id)
+		}
+	}
+}
+
+// compact drops expired IDs and rewrites the file with the remaining ones.
+// The new file replaces the old one atomically, so a crash leaves either.
+// The caller must hold mu (or own l exclusively).
+func (l *SentLog) compact() error {
+	l.prune(time.Now())
+
+	tmp := l.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite sent log: %w", err)
+	}
+	w := bufio.NewWriter(file)
+	for id, sentAt := range l.sent {
+		fmt.Fprintf(w, "%s %d\n", id, sentAt.Unix())
+	}
+	if err = w.Flush(); err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to rewrite sent log: %w", err)
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to rewrite sent log: %w", err)
+	}
+
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.file, err = os.OpenFile(l.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open sent log: %w", err)
+	}
+	l.lines = len(l.sent)
+	return nil
+}
+
+// Contains reports whether the message was sent within the retention period
+func (l *SentLog) Contains(messageID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sentAt, ok := l.sent[messageID]
+	return ok && time.Since(sentAt) < l.retention
+}
+
+// Record durably marks the message as sent; it returns once the ID has been
+// synced to disk
+func (l *SentLog) Record(messageID string) error {
+	if messageID == "" {
+		return nil
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := fmt.Fprintf(l.file, "%s %d\n", messageID, now.Unix()); err != nil {
+		return fmt.Errorf("failed to write sent log: %w", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync sent log: %w", err)
+	}
+	l.sent[messageID] = now
+	l.lines++
+
+	if now.Sub(l.lastPrune) > time.Minute {
+		l.prune(now)
+		// Rewrite the file once expired entries outnumber the live ones
+		if l.lines > 1024 && l.lines > 2*len(l.sent) {
+			return l.compact()
+		}
+	}
+	return nil
+}
+
+// Close closes the file
+func (l *SentLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
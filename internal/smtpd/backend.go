@@ -0,0 +1,91 @@
+package smtpd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Arturstriker3/api-go/internal/tcp"
+	"github.com/Arturstriker3/api-go/pkg/protocol"
+)
+
+// Backend decides who may use a listener and what happens to the messages it
+// accepts. The SMTP conversation itself is the same for every backend.
+type Backend interface {
+	// Name identifies the listener in logs and metrics
+	Name() string
+	// RequireAuth reports whether clients must authenticate before MAIL
+	RequireAuth() bool
+	// Recipient accepts or refuses a RCPT TO address
+	Recipient(address string) error
+	// Deliver handles a complete message and returns the ID it is known by
+	Deliver(sess *tcp.Session, from string, to []string, raw []byte) (string, error)
+}
+
+// Error is the SMTP reply a Backend uses to refuse a recipient or message.
+// Any other error is answered with a temporary failure so the client retries.
+type Error struct {
+	Code int
+	// Message starts with the enhanced status code, e.g. "5.7.1 Relay denied"
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s", e.Code, e.Message)
+}
+
+// submissionBackend queues messages from authenticated clients through the
+// protocol handler
+type submissionBackend struct {
+	handler *tcp.Handler
+}
+
+// NewSubmissionBackend returns the backend of the SMTP submission listener
+func NewSubmissionBackend(handler *tcp.Handler) Backend {
+	return &submissionBackend{handler: handler}
+}
+
+func (b *submissionBackend) Name() string {
+	return "submission"
+}
+
+func (b *submissionBackend) RequireAuth() bool {
+	return true
+}
+
+// Recipient accepts any address; the handler validates them when queueing
+func (b *submissionBackend) Recipient(address string) error {
+	return nil
+}
+
+func (b *submissionBackend) Deliver(sess *tcp.Session, from string, to []string, raw []byte) (string, error) {
+	emailData, err := parseMessage(raw, to)
+	if err != nil {
+		return "", &Error{Code: 554, Message: "5.6.0 " + err.Error()}
+	}
+
+	payload, _ := json.Marshal(emailData)
+	response := b.handler.HandleRequest(sess, &protocol.Request{Op: protocol.OpSend, Payload: payload})
+	if !response.OK {
+		return "", replyError(response.Error)
+	}
+
+	var result protocol.SendResult
+	response.DecodeResult(&result)
+	return result.MessageID, nil
+}
+
+// replyError maps a protocol error to an SMTP reply
+func replyError(protoErr *protocol.Error) *Error {
+	switch protoErr.Code {
+	case protocol.CodeForbidden:
+		return &Error{Code: 550, Message: "5.7.1 " + protoErr.Message}
+	case protocol.CodeInvalidEmail, protocol.CodeInvalidPayload:
+		return &Error{Code: 554, Message: "5.6.0 " + protoErr.Message}
+	case protocol.CodeAttachmentTooLarge:
+		return &Error{Code: 552, Message: "5.3.4 " + protoErr.Message}
+	case protocol.CodeBusy:
+		return &Error{Code: 451, Message: "4.3.2 " + protoErr.Message}
+	default:
+		return &Error{Code: 451, Message: "4.3.0 " + protoErr.Message}
+	}
+}
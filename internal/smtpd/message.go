@@ -0,0 +1,90 @@
+package smtpd
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"slices"
+	"strings"
+
+	"github.com/Arturstriker3/api-go/internal/email"
+	"github.com/Arturstriker3/api-go/internal/mimeparse"
+)
+
+// errNoBody is returned for messages without a text or HTML part
+var errNoBody = errors.New("message has no text or HTML body")
+
+// parseMessage converts a received RFC 5322 message into the internal email
+// representation. Recipients come from the envelope: those listed in the To
+// or Cc header keep their place and the rest are sent as Bcc, so blind
+// copies never show up in the headers. Attachments are passed on, inline
+// when the HTML body references their Content-ID.
+func parseMessage(raw []byte, recipients []string) (*email.EmailData, error) {
+	msg, err := mimeparse.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.HTML == "" && msg.Text == "" {
+		return nil, errNoBody
+	}
+	// The sender can re-encode, so refuse rather than queue a garbled body
+	if len(msg.UnknownCharsets) > 0 {
+		return nil, fmt.Errorf("unsupported charset %q", msg.UnknownCharsets[0])
+	}
+
+	// The text part becomes the plain-text alternative, or the only body of
+	// messages without HTML
+	emailData := &email.EmailData{
+		Subject:  msg.Subject,
+		Body:     msg.HTML,
+		TextBody: msg.Text,
+	}
+
+	to, cc := headerAddresses(msg.Header, "To"), headerAddresses(msg.Header, "Cc")
+	for _, recipient := range recipients {
+		switch key := strings.ToLower(recipient); {
+		case to[key]:
+			emailData.To = append(emailData.To, recipient)
+		case cc[key]:
+			emailData.Cc = append(emailData.Cc, recipient)
+		default:
+			emailData.Bcc = append(emailData.Bcc, recipient)
+		}
+	}
+
+	if replyTo, err := msg.Header.AddressList("Reply-To"); err == nil && len(replyTo) > 0 {
+		emailData.ReplyTo = replyTo[0].String()
+	}
+
+	// Some clients give every part a Content-ID; only the parts the HTML
+	// body shows stay inline
+	referenced := email.ContentIDReferences(msg.HTML)
+	for i, attachment := range msg.Attachments {
+		filename := attachment.Filename
+		if filename == "" {
+			filename = fmt.Sprintf("attachment-%d", i+1)
+		}
+		var contentID string
+		if slices.Contains(referenced, attachment.ContentID) {
+			contentID = attachment.ContentID
+		}
+		emailData.Attachments = append(emailData.Attachments, email.Attachment{
+			Filename:    filename,
+			ContentType: attachment.ContentType,
+			Content:     attachment.Data,
+			ContentID:   contentID,
+		})
+	}
+	return emailData, nil
+}
+
+// headerAddresses returns the lowercased addresses listed in a header
+func headerAddresses(header mail.Header, key string) map[string]bool {
+	addresses := make(map[string]bool)
+	list, _ := header.AddressList(key)
+	for _, address := range list {
+		addresses[strings.ToLower(address.Address)] = true
+	}
+	return addresses
+}
@@ -0,0 +1,176 @@
+package smtpd
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Arturstriker3/api-go/config"
+	"github.com/Arturstriker3/api-go/internal/metrics"
+	"github.com/Arturstriker3/api-go/internal/tcp"
+)
+
+// Server is an SMTP listener. What it accepts and where messages go is up to
+// its Backend: the submission backend authenticates clients with their
+// GoMailer key and queues through the same Handler as the TCP protocol, so
+// messages get the same validation, scopes and metrics.
+type Server struct {
+	config    *config.Config
+	opts      config.SMTPDConfig
+	handler   *tcp.Handler
+	backend   Backend
+	tlsConfig *tls.Config
+	listener  net.Listener
+
+	connsMu  sync.Mutex
+	conns    map[net.Conn]struct{}
+	limiter  *tcp.ConnLimiter
+	connsWG  sync.WaitGroup
+	draining atomic.Bool
+}
+
+// NewServer creates an SMTP listener configured by opts. tlsConfig enables
+// STARTTLS and may be nil when no certificate is available.
+func NewServer(cfg *config.Config, opts config.SMTPDConfig, handler *tcp.Handler, backend Backend, tlsConfig *tls.Config) *Server {
+	return &Server{
+		config:    cfg,
+		opts:      opts,
+		handler:   handler,
+		backend:   backend,
+		tlsConfig: tlsConfig,
+		conns:     make(map[net.Conn]struct{}),
+		limiter:   tcp.NewConnLimiter(opts.MaxConnections, opts.MaxConnectionsPerIP),
+	}
+}
+
+// Start accepts SMTP connections until Shutdown is called
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.opts.Address)
+	if err != nil {
+		return fmt.Errorf("failed to start SMTP listener: %w", err)
+	}
+	s.connsMu.Lock()
+	s.listener = listener
+	draining := s.draining.Load()
+	s.connsMu.Unlock()
+	if draining {
+		listener.Close()
+		return nil
+	}
+
+	if s.tlsConfig != nil {
+		log.Printf("📮 SMTP %s listening on %s (STARTTLS available)", s.backend.Name(), s.opts.Address)
+	} else {
+		log.Printf("🟡 SMTP %s listening on %s (INSECURE, no STARTTLS)", s.backend.Name(), s.opts.Address)
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			log.Printf("Error accepting SMTP %s connection: %v", s.backend.Name(), err)
+			continue
+		}
+
+		if !s.handler.AllowConnection(conn.RemoteAddr().String()) {
+			conn.Close()
+			continue
+		}
+		if reason := s.trackConn(conn); reason != "" {
+			if reason == rejectDraining {
+				conn.Close()
+				continue
+			}
+			metrics.SMTPDConnectionsRejected.WithLabelValues(s.backend.Name(), reason).Inc()
+			log.Printf("🟡 Refused SMTP %s connection from %s: %s reached", s.backend.Name(), conn.RemoteAddr(), reason)
+			go s.refuse(conn)
+			continue
+		}
+
+		go func() {
+			defer s.untrackConn(conn)
+
+			metrics.SMTPDConnections.WithLabelValues(s.backend.Name()).Inc()
+			defer metrics.SMTPDConnections.WithLabelValues(s.backend.Name()).Dec()
+
+			newSession(s, conn).serve()
+		}()
+	}
+}
+
+// refuse greets a connection over the limits with 421, which RFC 5321 lets a
+// busy server send instead of its greeting, so clients retry later
+func (s *Server) refuse(conn net.Conn) {
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	fmt.Fprintf(conn, "421 4.7.0 %s Too many connections, try again later\r\n", s.opts.Hostname)
+}
+
+// rejectDraining refuses connections accepted while shutting down
+const rejectDraining = "draining"
+
+// trackConn registers a new connection. It returns why the connection must be
+// refused, or "" when it was accepted.
+func (s *Server) trackConn(conn net.Conn) string {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+
+	if s.draining.Load() {
+		return rejectDraining
+	}
+	if reason := s.limiter.Acquire(conn.RemoteAddr().String()); reason != "" {
+		return reason
+	}
+	s.conns[conn] = struct{}{}
+	s.connsWG.Add(1)
+	return ""
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.connsMu.Lock()
+	delete(s.conns, conn)
+	s.limiter.Release(conn.RemoteAddr().String())
+	s.connsMu.Unlock()
+	s.connsWG.Done()
+}
+
+// Shutdown stops accepting connections and tells open sessions the service is
+// closing. A message whose DATA was not acknowledged yet is retried by the
+// client, as SMTP requires.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.connsMu.Lock()
+	s.draining.Store(true)
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	s.connsMu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.connsWG.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		s.connsMu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.connsMu.Unlock()
+		return ctx.Err()
+	}
+}
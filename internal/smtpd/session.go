@@ -0,0 +1,483 @@
+package smtpd
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Arturstriker3/api-go/internal/metrics"
+	"github.com/Arturstriker3/api-go/internal/tcp"
+	"github.com/Arturstriker3/api-go/pkg/protocol"
+)
+
+// Line length limits of RFC 5321 section 4.5.3.1, CRLF included
+const (
+	maxCommandLine = 512
+	maxTextLine    = 1000
+)
+
+// errLineTooLong is returned by readLine once an overlong line has been
+// discarded
+var errLineTooLong = errors.New("line too long")
+
+// session is the state of one SMTP conversation
+type session struct {
+	server *Server
+	conn   net.Conn
+	text   *textproto.Conn
+	sess   *tcp.Session
+
+	helo string
+	from string
+	to   []string
+	quit bool
+}
+
+func newSession(server *Server, conn net.Conn) *session {
+	return &session{
+		server: server,
+		conn:   conn,
+		text:   textproto.NewConn(conn),
+		sess:   tcp.NewSession(conn.RemoteAddr().String(), false),
+	}
+}
+
+// serve runs the command loop until the client quits or the connection fails
+func (c *session) serve() {
+	defer c.conn.Close()
+
+	log.Printf("📮 New SMTP connection from %s", c.conn.RemoteAddr())
+	c.reply(220, "%s ESMTP GoMailer ready", c.server.opts.Hostname)
+
+	for !c.quit && !c.sess.Closing() {
+		c.setDeadline()
+		line, err := c.readLine(maxCommandLine)
+		if errors.Is(err, errLineTooLong) {
+			c.reply(500, "5.5.2 Line too long")
+			continue
+		}
+		if err != nil {
+			if c.server.draining.Load() {
+				c.reply(421, "4.3.2 Service shutting down")
+			} else if errors.Is(err, os.ErrDeadlineExceeded) {
+				c.reply(421, "4.4.2 Idle timeout, closing connection")
+			}
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		c.handle(strings.ToUpper(verb), strings.TrimSpace(arg))
+
+		if c.server.draining.Load() && !c.quit {
+			c.reply(421, "4.3.2 Service shutting down")
+			return
+		}
+	}
+}
+
+// readLine reads one line of at most limit octets. The rest of a longer line
+// is read and dropped instead of buffered, so a client that never ends its
+// line cannot exhaust memory; the read deadline bounds how long it may try.
+func (c *session) readLine(limit int) (string, error) {
+	var line []byte
+	tooLong := false
+	for {
+		chunk, err := c.text.R.ReadSlice('\n')
+		if !tooLong {
+			if len(line)+len(chunk) > limit {
+				tooLong, line = true, nil
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if tooLong {
+			return "", errLineTooLong
+		}
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
+
+func (c *session) setDeadline() {
+	var deadline time.Time
+	if timeout := c.server.config.TCP.IdleTimeout; timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	c.conn.SetReadDeadline(deadline)
+	if c.server.draining.Load() {
+		c.conn.SetReadDeadline(time.Now())
+	}
+}
+
+func (c *session) handle(verb, arg string) {
+	switch verb {
+	case "HELO":
+		c.helo = arg
+		c.reset()
+		c.reply(250, "%s", c.server.opts.Hostname)
+	case "EHLO":
+		c.helo = arg
+		c.reset()
+		c.ehlo()
+	case "STARTTLS":
+		c.startTLS()
+	case "AUTH":
+		c.auth(arg)
+	case "MAIL":
+		c.mail(arg)
+	case "RCPT":
+		c.rcpt(arg)
+	case "DATA":
+		c.data()
+	case "RSET":
+		c.reset()
+		c.reply(250, "2.0.0 OK")
+	case "NOOP":
+		c.reply(250, "2.0.0 OK")
+	case "VRFY":
+		c.reply(252, "2.5.0 Cannot verify user")
+	case "QUIT":
+		c.quit = true
+		c.reply(221, "2.0.0 Bye")
+	default:
+		c.reply(502, "5.5.1 Command not implemented")
+	}
+}
+
+func (c *session) ehlo() {
+	lines := []string{
+		c.server.opts.Hostname,
+		"8BITMIME",
+		"ENHANCEDSTATUSCODES",
+		"SIZE " + strconv.Itoa(c.server.opts.MaxMessageSize),
+	}
+	if c.server.tlsConfig != nil && !c.sess.TLS {
+		lines = append(lines, "STARTTLS")
+	}
+	if c.server.backend.RequireAuth() && c.authAllowed() {
+		lines = append(lines, "AUTH PLAIN LOGIN")
+	}
+
+	for i, line := range lines {
+		sep := "-"
+		if i == len(lines)-1 {
+			sep = " "
+		}
+		c.text.PrintfLine("250%s%s", sep, line)
+	}
+}
+
+// authAllowed reports whether AUTH may be used on the connection as it is
+func (c *session) authAllowed() bool {
+	return c.sess.TLS || !c.server.opts.RequireTLS
+}
+
+func (c *session) startTLS() {
+	if c.server.tlsConfig == nil {
+		c.reply(454, "4.7.0 TLS not available")
+		return
+	}
+	if c.sess.TLS {
+		c.reply(503, "5.5.1 TLS already active")
+		return
+	}
+	c.reply(220, "2.0.0 Ready to start TLS")
+
+	tlsConn := tls.Server(c.conn, c.server.tlsConfig)
+	if timeout := c.server.config.TCP.HandshakeTimeout; timeout > 0 {
+		tlsConn.SetDeadline(time.Now().Add(timeout))
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		log.Printf("🔴 SMTP STARTTLS handshake failed from %s: %v", c.conn.RemoteAddr(), err)
+		c.quit = true
+		return
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	// RFC 3207: forget everything learned before the handshake
+	c.conn = tlsConn
+	c.text = textproto.NewConn(tlsConn)
+	c.sess = tcp.NewSession(c.sess.RemoteAddr, true)
+	c.helo = ""
+	c.reset()
+
+	if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+		c.server.handler.AuthenticateCertificate(c.sess, certs[0])
+	}
+}
+
+func (c *session) auth(arg string) {
+	switch {
+	case !c.server.backend.RequireAuth():
+		c.reply(503, "5.5.1 Authentication not enabled")
+		return
+	case c.helo == "":
+		c.reply(503, "5.5.1 Send EHLO first")
+		return
+	case c.sess.Authenticated:
+		c.reply(503, "5.5.1 Already authenticated")
+		return
+	case !c.authAllowed():
+		c.reply(530, "5.7.0 Must issue a STARTTLS command first")
+		return
+	}
+
+	mechanism, initial, _ := strings.Cut(arg, " ")
+	var username, password string
+	var err error
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		username, password, err = c.authPlain(initial)
+	case "LOGIN":
+		username, password, err = c.authLogin(initial)
+	default:
+		c.reply(504, "5.5.4 Unrecognized authentication type")
+		return
+	}
+	if errors.Is(err, errLineTooLong) {
+		c.reply(500, "5.5.2 Line too long")
+		return
+	}
+	// The username is the key ID; without it the secret would be hashed
+	// against every key
+	if err == nil && username == "" {
+		err = errors.New("username must be the key ID")
+	}
+	if err != nil {
+		c.reply(501, "5.5.2 %v", err)
+		return
+	}
+
+	payload, _ := json.Marshal(protocol.AuthPayload{KeyID: username, Secret: password})
+	response := c.server.handler.HandleRequest(c.sess, &protocol.Request{Op: protocol.OpAuth, Payload: payload})
+	if !response.OK {
+		if response.Error.Code == protocol.CodeHMACRequired {
+			c.reply(538, "5.7.11 Encryption required for requested authentication mechanism")
+		} else {
+			c.reply(535, "5.7.8 Authentication credentials invalid")
+		}
+		return
+	}
+	c.reply(235, "2.7.0 Authentication successful")
+}
+
+// authPlain decodes an RFC 4616 response: authzid NUL authcid NUL passwd
+func (c *session) authPlain(initial string) (string, string, error) {
+	if initial == "" {
+		var err error
+		if initial, err = c.challenge(""); err != nil {
+			return "", "", err
+		}
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(initial)
+	if err != nil {
+		return "", "", errors.New("invalid base64 encoding")
+	}
+	parts := strings.Split(string(decoded), "\x00")
+	if len(parts) != 3 {
+		return "", "", errors.New("malformed PLAIN response")
+	}
+	return parts[1], parts[2], nil
+}
+
+func (c *session) authLogin(initial string) (string, string, error) {
+	encodedUser := initial
+	if encodedUser == "" {
+		var err error
+		if encodedUser, err = c.challenge("Username:"); err != nil {
+			return "", "", err
+		}
+	}
+	username, err := base64.StdEncoding.DecodeString(encodedUser)
+	if err != nil {
+		return "", "", errors.New("invalid base64 encoding")
+	}
+
+	encodedPassword, err := c.challenge("Password:")
+	if err != nil {
+		return "", "", err
+	}
+	password, err := base64.StdEncoding.DecodeString(encodedPassword)
+	if err != nil {
+		return "", "", errors.New("invalid base64 encoding")
+	}
+	return string(username), string(password), nil
+}
+
+// challenge sends a 334 prompt and reads the client's base64 answer
+func (c *session) challenge(prompt string) (string, error) {
+	c.text.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(prompt)))
+	line, err := c.readLine(maxTextLine)
+	if errors.Is(err, errLineTooLong) {
+		return "", err
+	}
+	if err != nil {
+		c.quit = true
+		return "", err
+	}
+	if line == "*" {
+		return "", errors.New("authentication cancelled")
+	}
+	return strings.TrimSpace(line), nil
+}
+
+func (c *session) mail(arg string) {
+	switch {
+	case c.helo == "":
+		c.reply(503, "5.5.1 Send EHLO first")
+		return
+	case c.server.backend.RequireAuth() && !c.sess.Authenticated:
+		c.reply(530, "5.7.0 Authentication required")
+		return
+	case c.from != "":
+		c.reply(503, "5.5.1 Sender already specified")
+		return
+	}
+
+	address, params, err := parsePath(arg, "FROM:")
+	if err != nil {
+		c.reply(501, "5.5.4 %v", err)
+		return
+	}
+	for _, param := range params {
+		name, value, _ := strings.Cut(param, "=")
+		if strings.EqualFold(name, "SIZE") {
+			if size, err := strconv.Atoi(value); err == nil && size > c.server.opts.MaxMessageSize {
+				c.reply(552, "5.3.4 Message size exceeds fixed maximum message size")
+				return
+			}
+		}
+	}
+
+	// The null reverse-path "<>" is allowed for bounces
+	if address == "" {
+		address = "<>"
+	}
+	c.from = address
+	c.reply(250, "2.1.0 OK")
+}
+
+func (c *session) rcpt(arg string) {
+	if c.from == "" {
+		c.reply(503, "5.5.1 Need MAIL command first")
+		return
+	}
+	if max := c.server.opts.MaxRecipients; max > 0 && len(c.to) >= max {
+		c.reply(452, "4.5.3 Too many recipients")
+		return
+	}
+
+	address, _, err := parsePath(arg, "TO:")
+	if err != nil || address == "" {
+		c.reply(501, "5.1.3 Bad recipient address syntax")
+		return
+	}
+	if _, err := mail.ParseAddress(address); err != nil {
+		c.reply(553, "5.1.3 Bad recipient address syntax")
+		return
+	}
+	if err := c.server.backend.Recipient(address); err != nil {
+		c.replyError(err)
+		return
+	}
+
+	c.to = append(c.to, address)
+	c.reply(250, "2.1.5 OK")
+}
+
+func (c *session) data() {
+	if len(c.to) == 0 {
+		c.reply(503, "5.5.1 Need RCPT command first")
+		return
+	}
+	c.reply(354, "Start mail input; end with <CRLF>.<CRLF>")
+
+	// Give the whole message the idle timeout rather than each line
+	c.setDeadline()
+
+	max := c.server.opts.MaxMessageSize
+	reader := c.text.DotReader()
+	raw, err := io.ReadAll(io.LimitReader(reader, int64(max)+1))
+	if err != nil {
+		c.quit = true
+		return
+	}
+	if len(raw) > max {
+		io.Copy(io.Discard, reader)
+		c.reset()
+		metrics.SMTPDMessages.WithLabelValues(c.server.backend.Name(), "rejected").Inc()
+		c.reply(552, "5.3.4 Message size exceeds fixed maximum message size")
+		return
+	}
+
+	from, recipients := c.from, c.to
+	c.reset()
+
+	id, err := c.server.backend.Deliver(c.sess, from, recipients, raw)
+	if err != nil {
+		metrics.SMTPDMessages.WithLabelValues(c.server.backend.Name(), "rejected").Inc()
+		c.replyError(err)
+		return
+	}
+
+	metrics.SMTPDMessages.WithLabelValues(c.server.backend.Name(), "accepted").Inc()
+	c.reply(250, "2.0.0 OK queued as %s", id)
+}
+
+// replyError answers with the reply a Backend chose, or a temporary failure
+// for unexpected errors
+func (c *session) replyError(err error) {
+	var reply *Error
+	if errors.As(err, &reply) {
+		c.reply(reply.Code, "%s", reply.Message)
+		return
+	}
+	log.Printf("🔴 SMTP %s failed to handle message from %s: %v", c.server.backend.Name(), c.sess.RemoteAddr, err)
+	c.reply(451, "4.3.0 Temporary failure, try again later")
+}
+
+// reset forgets the current transaction
+func (c *session) reset() {
+	c.from = ""
+	c.to = nil
+}
+
+func (c *session) reply(code int, format string, args ...interface{}) {
+	c.text.PrintfLine("%d %s", code, fmt.Sprintf(format, args...))
+}
+
+// parsePath extracts the address from "FROM:<addr> PARAM=value ..."
+func parsePath(arg, prefix string) (string, []string, error) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, fmt.Errorf("syntax: expected %s<address>", prefix)
+	}
+
+	fields := strings.Fields(strings.TrimSpace(arg[len(prefix):]))
+	if len(fields) == 0 {
+		return "", nil, fmt.Errorf("syntax: expected %s<address>", prefix)
+	}
+
+	path := fields[0]
+	if !strings.HasPrefix(path, "<") || !strings.HasSuffix(path, ">") {
+		return "", nil, fmt.Errorf("syntax: address must be enclosed in <>")
+	}
+	return path[1 : len(path)-1], fields[1:], nil
+}
@@ -0,0 +1,51 @@
+package tcp
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/Arturstriker3/api-go/internal/metrics"
+	"github.com/Arturstriker3/api-go/pkg/protocol"
+)
+
+// AllowConnection reports whether a client may connect at all. Denylisted and
+// banned addresses are dropped before any byte is read.
+func (h *Handler) AllowConnection(remoteAddr string) bool {
+	if err := h.guard.Check(remoteAddr); err != nil {
+		log.Printf("⛔ Refused connection from %s: %v", remoteAddr, err)
+		return false
+	}
+	return true
+}
+
+// RecordCertificateFailure counts a TLS handshake rejected because of the
+// client certificate as a failed authentication
+func (h *Handler) RecordCertificateFailure(remoteAddr string, err error) {
+	metrics.TLSAuthErrors.Inc()
+	metrics.AuthFailuresByKey.WithLabelValues("unknown", "certificate").Inc()
+	log.Printf("🔴 Client certificate rejected from %s: %v", remoteAddr, err)
+	h.guard.RecordFailure(remoteAddr)
+}
+
+func (h *Handler) handleListBans(req *protocol.Request) *protocol.Response {
+	bans := h.guard.Bans()
+
+	result := protocol.ListBansResult{Bans: make([]protocol.Ban, len(bans))}
+	for i, ban := range bans {
+		result.Bans[i] = protocol.Ban{IP: ban.IP, Until: ban.Until, Bans: ban.Bans, Failures: ban.Failures}
+	}
+	return protocol.NewResult(req, result)
+}
+
+func (h *Handler) handleClearBans(sess *Session, req *protocol.Request) *protocol.Response {
+	var payload protocol.ClearBansPayload
+	if len(req.Payload) > 0 {
+		if err := json.Unmarshal(req.Payload, &payload); err != nil {
+			return protocol.NewError(req, protocol.CodeInvalidPayload, "Clear bans payload must be an object with an optional ip")
+		}
+	}
+
+	cleared := h.guard.ClearBans(payload.IP)
+	log.Printf("🔑 Key %q cleared %d ban(s) (ip %q)", sess.KeyID, cleared, payload.IP)
+	return protocol.NewResult(req, protocol.ClearBansResult{Cleared: cleared})
+}
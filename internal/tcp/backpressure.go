@@ -0,0 +1,43 @@
+package tcp
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Arturstriker3/api-go/internal/metrics"
+	"github.com/Arturstriker3/api-go/pkg/protocol"
+)
+
+// busyMessage is returned, with the seconds to wait, while sends are refused
+const busyMessage = "Queue backlog is too deep, retry after %d seconds"
+
+// throttle applies backpressure before emails are queued. Above the soft
+// limit it sleeps for a delay that grows linearly with the backlog up to
+// BACKPRESSURE_MAX_DELAY; from the hard limit on it returns how long the
+// client should wait before retrying, and ok is false.
+func (h *Handler) throttle(sess *Session) (retryAfter int, ok bool) {
+	cfg := h.config.Backpressure
+	backlog := h.emailService.Backlog()
+
+	if cfg.HardLimit > 0 && backlog >= cfg.HardLimit {
+		metrics.Backpressure.WithLabelValues("rejected").Inc()
+		log.Printf("⛔ Refusing email from key %q: %d messages queued (limit %d)", sess.KeyID, backlog, cfg.HardLimit)
+		return int(cfg.RetryAfter.Round(time.Second).Seconds()), false
+	}
+
+	if cfg.SoftLimit > 0 && backlog > cfg.SoftLimit && cfg.MaxDelay > 0 {
+		delay := time.Duration(float64(cfg.MaxDelay) * float64(backlog-cfg.SoftLimit) / float64(cfg.HardLimit-cfg.SoftLimit))
+		metrics.Backpressure.WithLabelValues("delayed").Inc()
+		metrics.BackpressureDelay.Observe(delay.Seconds())
+		time.Sleep(delay)
+	}
+	return 0, true
+}
+
+// busyResponse tells an envelope client to retry later
+func busyResponse(req *protocol.Request, retryAfter int) *protocol.Response {
+	response := protocol.NewError(req, protocol.CodeBusy, fmt.Sprintf(busyMessage, retryAfter))
+	response.Error.RetryAfter = retryAfter
+	return response
+}
@@ -1,36 +1,440 @@
 package tcp
 
 import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"time"
 
 	"github.com/Arturstriker3/api-go/config"
+	"github.com/Arturstriker3/api-go/internal/auth"
 	"github.com/Arturstriker3/api-go/internal/email"
 	"github.com/Arturstriker3/api-go/internal/metrics"
+	"github.com/Arturstriker3/api-go/pkg/protocol"
 )
 
 type Handler struct {
 	config       *config.Config
 	emailService *email.Service
+	keys         *auth.KeyStore
+	guard        *auth.Guard
+	// idempotency is nil when IDEMPOTENCY_WINDOW is 0
+	idempotency *idempotencyStore
 }
 
-func NewHandler(cfg *config.Config, emailService *email.Service) *Handler {
-	return &Handler{
+func NewHandler(cfg *config.Config, emailService *email.Service, keys *auth.KeyStore, guard *auth.Guard) *Handler {
+	handler := &Handler{
 		config:       cfg,
 		emailService: emailService,
+		keys:         keys,
+		guard:        guard,
 	}
+	if cfg.Idempotency.Window > 0 {
+		handler.idempotency = newIdempotencyStore(cfg.Idempotency.Window)
+	}
+	return handler
+}
+
+// opScopes lists the scope each authenticated operation requires
+var opScopes = map[string]auth.Scope{
+	protocol.OpSend:   auth.ScopeSend,
+	protocol.OpBatch:  auth.ScopeSend,
+	protocol.OpCancel: auth.ScopeSend,
+	protocol.OpStatus: auth.ScopeStatus,
+
+	protocol.OpListBans:  auth.ScopeAdmin,
+	protocol.OpClearBans: auth.ScopeAdmin,
+}
+
+// HandleFrame routes a frame to the envelope protocol when it carries an
+// "op" field and to the legacy message shapes otherwise
+func (h *Handler) HandleFrame(sess *Session, frame []byte) []byte {
+	if req, ok := protocol.ParseRequest(frame); ok {
+		// Envelope clients that skip HELLO implicitly speak version 2
+		if sess.Version == 0 && req.Op != protocol.OpHello {
+			sess.Version = protocol.Version2
+		}
+		responseBytes, _ := json.Marshal(h.HandleRequest(sess, req))
+		return responseBytes
+	}
+
+	if sess.Version == 0 {
+		sess.Version = protocol.Version1
+	}
+	if sess.Version == protocol.Version1 && h.config.TCP.MinProtocolVersion > protocol.Version1 {
+		log.Printf("🔴 Rejecting legacy protocol client from %s", sess.RemoteAddr)
+		sess.Close()
+		return createErrorResponse("Legacy protocol is disabled, upgrade the client")
+	}
+	if sess.Version >= protocol.Version2 {
+		responseBytes, _ := json.Marshal(protocol.NewError(nil, protocol.CodeInvalidRequest, "Message must be wrapped in an envelope with an \"op\" field"))
+		return responseBytes
+	}
+	return h.HandleMessage(sess, frame)
+}
+
+// HandleRequest executes a single envelope request
+func (h *Handler) HandleRequest(sess *Session, req *protocol.Request) *protocol.Response {
+	switch req.Op {
+	case protocol.OpHello:
+		return h.handleHello(sess, req)
+	case protocol.OpChallenge:
+		return h.handleChallenge(sess, req)
+	case protocol.OpAuth:
+		return h.handleAuth(sess, req)
+	case protocol.OpPing:
+		return protocol.NewResult(req, protocol.PingResult{Pong: true, ServerTime: time.Now().Unix()})
+	}
+
+	if !sess.Authenticated {
+		return protocol.NewError(req, protocol.CodeAuthRequired, "Authentication required")
+	}
+
+	response := h.handleAuthorized(sess, req)
+
+	status := "ok"
+	if !response.OK {
+		status = "error"
+	}
+	metrics.RequestsByKey.WithLabelValues(sess.KeyID, req.Op, status).Inc()
+	return response
+}
+
+// handleAuthorized runs an operation for an authenticated session after
+// checking that its key grants the required scope
+func (h *Handler) handleAuthorized(sess *Session, req *protocol.Request) *protocol.Response {
+	scope, known := opScopes[req.Op]
+	if !known {
+		return protocol.NewError(req, protocol.CodeUnknownOp, "Unknown operation: "+req.Op)
+	}
+	if err := h.keys.Authorize(sess.KeyID, scope); err != nil {
+		log.Printf("🔴 Key %q denied %s from %s: %v", sess.KeyID, req.Op, sess.RemoteAddr, err)
+		return protocol.NewError(req, protocol.CodeForbidden, err.Error())
+	}
+
+	switch req.Op {
+	case protocol.OpSend:
+		return h.handleSend(sess, req)
+	case protocol.OpBatch:
+		return h.handleBatch(sess, req)
+	case protocol.OpStatus:
+		return h.handleStatus(sess, req)
+	case protocol.OpCancel:
+		return h.handleCancel(sess, req)
+	case protocol.OpListBans:
+		return h.handleListBans(req)
+	case protocol.OpClearBans:
+		return h.handleClearBans(sess, req)
+	default:
+		return protocol.NewError(req, protocol.CodeUnknownOp, "Unknown operation: "+req.Op)
+	}
+}
+
+// serverCapabilities lists the optional protocol features this server offers
+var serverCapabilities = []string{
+	protocol.CapFramingNewline,
+	protocol.CapFramingLength,
+	protocol.CapBatch,
+	protocol.CapAuthSCRAM,
+}
+
+// challengeTTL is how long a nonce issued by the challenge operation is valid
+const challengeTTL = 30 * time.Second
+
+func (h *Handler) handleHello(sess *Session, req *protocol.Request) *protocol.Response {
+	if sess.Version != 0 {
+		return protocol.NewError(req, protocol.CodeUnexpectedHello, "HELLO must be the first message on a connection")
+	}
+
+	var payload protocol.HelloPayload
+	if err := json.Unmarshal(req.Payload, &payload); err != nil || payload.Version <= 0 {
+		return protocol.NewError(req, protocol.CodeInvalidPayload, "HELLO payload must contain a protocol version")
+	}
+
+	minVersion := h.config.TCP.MinProtocolVersion
+	if minVersion < protocol.Version1 {
+		minVersion = protocol.Version1
+	}
+
+	// Speak the newest version both sides understand
+	version := payload.Version
+	if version > protocol.CurrentVersion {
+		version = protocol.CurrentVersion
+	}
+	if version < minVersion {
+		sess.Close()
+		return protocol.NewError(req, protocol.CodeUnsupportedVersion,
+			fmt.Sprintf("Protocol version %d is not supported (minimum %d)", payload.Version, minVersion))
+	}
+
+	framing := payload.Framing
+	if framing != "" {
+		if !protocol.HasCapability(serverCapabilities, "framing:"+string(framing)) {
+			return protocol.NewError(req, protocol.CodeUnsupportedFraming, "Unsupported framing: "+string(framing))
+		}
+		sess.Framing = framing
+	}
+
+	sess.Version = version
+	sess.Capabilities = payload.Capabilities
+	log.Printf("🤝 HELLO from %s: client %q, protocol v%d", sess.RemoteAddr, payload.Client, version)
+
+	return protocol.NewResult(req, protocol.HelloResult{
+		Version:      version,
+		MinVersion:   minVersion,
+		MaxVersion:   protocol.CurrentVersion,
+		Capabilities: serverCapabilities,
+		Framing:      framing,
+	})
+}
+
+func (h *Handler) handleChallenge(sess *Session, req *protocol.Request) *protocol.Response {
+	var payload protocol.ChallengePayload
+	if len(req.Payload) > 0 {
+		if err := json.Unmarshal(req.Payload, &payload); err != nil {
+			return protocol.NewError(req, protocol.CodeInvalidPayload, "Invalid challenge payload")
+		}
+	}
+	if payload.KeyID == "" {
+		payload.KeyID = auth.LegacyKeyID
+	}
+
+	nonceBytes := make([]byte, 32)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		log.Printf("🔴 Failed to generate auth challenge: %v", err)
+		return protocol.NewError(req, protocol.CodeInternal, "Failed to generate challenge")
+	}
+
+	sess.challenge = base64.StdEncoding.EncodeToString(nonceBytes)
+	sess.challengeKeyID = payload.KeyID
+	sess.challengeExpires = time.Now().Add(challengeTTL)
+
+	salt, iterations := h.keys.Challenge(payload.KeyID)
+	return protocol.NewResult(req, protocol.ChallengeResult{
+		Nonce:      sess.challenge,
+		Algorithm:  protocol.AlgorithmSCRAMSHA256,
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		Iterations: iterations,
+		ExpiresIn:  int(challengeTTL.Seconds()),
+	})
 }
 
-func (h *Handler) HandleMessage(message []byte) []byte {
-	// Check for authentication message
-	var authData struct {
-		Secret string `json:"secret"`
+func (h *Handler) handleAuth(sess *Session, req *protocol.Request) *protocol.Response {
+	var payload protocol.AuthPayload
+	if err := json.Unmarshal(req.Payload, &payload); err != nil || (payload.Secret == "" && payload.Proof == "") {
+		return protocol.NewError(req, protocol.CodeInvalidPayload, "Auth payload must contain a secret or a challenge proof")
+	}
+
+	var key *auth.Key
+	var serverSignature string
+	if payload.Proof != "" {
+		key, serverSignature = h.authenticateChallenge(sess, payload)
+	} else {
+		if h.plaintextAuthRejected(sess) {
+			sess.Close()
+			return protocol.NewError(req, protocol.CodeHMACRequired, "Plaintext secrets are not accepted on this connection, use the challenge operation")
+		}
+		key = h.authenticate(sess, payload.KeyID, payload.Secret)
+	}
+	if key == nil {
+		sess.Close()
+		return protocol.NewError(req, protocol.CodeAuthFailed, "Invalid authentication")
+	}
+
+	scopes := make([]string, len(key.Scopes))
+	for i, scope := range key.Scopes {
+		scopes[i] = string(scope)
+	}
+	return protocol.NewResult(req, protocol.AuthResult{
+		Authenticated:   true,
+		KeyID:           key.ID,
+		Scopes:          scopes,
+		ServerSignature: serverSignature,
+	})
+}
+
+func (h *Handler) handleSend(sess *Session, req *protocol.Request) *protocol.Response {
+	var emailData email.EmailData
+	if err := json.Unmarshal(req.Payload, &emailData); err != nil {
+		log.Printf("Error parsing email data: %v", err)
+		metrics.EmailErrors.Inc()
+		return protocol.NewError(req, protocol.CodeInvalidPayload, "Invalid email data format")
+	}
+
+	// Retries of an earlier send are answered even under backpressure; only
+	// new emails are delayed or refused
+	if !h.isReplay(sess, &emailData) {
+		if retryAfter, ok := h.throttle(sess); !ok {
+			return busyResponse(req, retryAfter)
+		}
 	}
+
+	result, replayed, err := h.queueEmail(sess, &emailData)
+	if err != nil {
+		return queueErrorResponse(req, err)
+	}
+
+	if replayed {
+		log.Printf("🔁 Message %s returned again for idempotency key %q of key %q", result.MessageID, emailData.IdempotencyKey, sess.KeyID)
+		return protocol.NewResult(req, result)
+	}
+	metrics.EmailsQueued.Inc()
+	metrics.EmailsQueuedByKey.WithLabelValues(sess.KeyID).Inc()
+	log.Printf("📨 Message %s queued by key %q", result.MessageID, sess.KeyID)
+	return protocol.NewResult(req, result)
+}
+
+func (h *Handler) handleBatch(sess *Session, req *protocol.Request) *protocol.Response {
+	var payload protocol.BatchPayload
+	if err := json.Unmarshal(req.Payload, &payload); err != nil || len(payload.Items) == 0 {
+		return protocol.NewError(req, protocol.CodeInvalidPayload, "Batch payload must contain a non-empty items array")
+	}
+	if h.config.TCP.MaxBatchSize > 0 && len(payload.Items) > h.config.TCP.MaxBatchSize {
+		return protocol.NewError(req, protocol.CodeBatchTooLarge,
+			fmt.Sprintf("Batch has %d items, maximum is %d", len(payload.Items), h.config.TCP.MaxBatchSize))
+	}
+
+	// The whole batch is delayed or refused at once, unless it only retries
+	// earlier sends
+	if !h.batchIsReplay(sess, payload.Items) {
+		if retryAfter, ok := h.throttle(sess); !ok {
+			return busyResponse(req, retryAfter)
+		}
+	}
+
+	result := protocol.BatchResult{Items: make([]protocol.BatchItemResult, len(payload.Items))}
+	for i, item := range payload.Items {
+		result.Items[i] = h.queueBatchItem(sess, i, item)
+		if result.Items[i].Status == protocol.ItemAccepted {
+			result.Accepted++
+		} else {
+			result.Rejected++
+		}
+	}
+
+	log.Printf("📦 Batch from key %q processed: %d accepted, %d rejected", sess.KeyID, result.Accepted, result.Rejected)
+	return protocol.NewResult(req, result)
+}
+
+// batchIsReplay reports whether every item of a batch is answered from an
+// earlier send, so the batch queues nothing new
+func (h *Handler) batchIsReplay(sess *Session, items []json.RawMessage) bool {
+	for _, item := range items {
+		var emailData email.EmailData
+		if err := json.Unmarshal(item, &emailData); err != nil || !h.isReplay(sess, &emailData) {
+			return false
+		}
+	}
+	return true
+}
+
+// queueBatchItem queues one item of a batch; failures only affect that item
+func (h *Handler) queueBatchItem(sess *Session, index int, item json.RawMessage) protocol.BatchItemResult {
+	var emailData email.EmailData
+	if err := json.Unmarshal(item, &emailData); err != nil {
+		metrics.EmailErrors.Inc()
+		return protocol.BatchItemResult{Index: index, Status: protocol.ItemRejected, Code: protocol.CodeInvalidPayload, Reason: "Invalid email data format"}
+	}
+
+	result, replayed, err := h.queueEmail(sess, &emailData)
+	if err != nil {
+		queueErr := queueErrorResponse(nil, err).Error
+		return protocol.BatchItemResult{Index: index, Status: protocol.ItemRejected, Code: queueErr.Code, Reason: queueErr.Message}
+	}
+
+	if !replayed {
+		metrics.EmailsQueued.Inc()
+		metrics.EmailsQueuedByKey.WithLabelValues(sess.KeyID).Inc()
+	}
+	return protocol.BatchItemResult{Index: index, Status: protocol.ItemAccepted, MessageID: result.MessageID, Replayed: replayed}
+}
+
+func (h *Handler) handleStatus(sess *Session, req *protocol.Request) *protocol.Response {
+	var payload protocol.StatusPayload
+	if err := json.Unmarshal(req.Payload, &payload); err != nil || payload.MessageID == "" {
+		return protocol.NewError(req, protocol.CodeInvalidPayload, "Status payload must contain a message_id")
+	}
+
+	status, err := h.emailService.Statuses().Get(payload.MessageID)
+	if err != nil || !h.canAccess(sess, status) {
+		return protocol.NewError(req, protocol.CodeNotFound, "No status recorded for message "+payload.MessageID)
+	}
+	return protocol.NewResult(req, toStatusResult(status))
+}
+
+func (h *Handler) handleCancel(sess *Session, req *protocol.Request) *protocol.Response {
+	var payload protocol.StatusPayload
+	if err := json.Unmarshal(req.Payload, &payload); err != nil || payload.MessageID == "" {
+		return protocol.NewError(req, protocol.CodeInvalidPayload, "Cancel payload must contain a message_id")
+	}
+
+	statuses := h.emailService.Statuses()
+	if status, err := statuses.Get(payload.MessageID); err != nil || !h.canAccess(sess, status) {
+		return protocol.NewError(req, protocol.CodeNotFound, "No status recorded for message "+payload.MessageID)
+	}
+	switch err := statuses.Cancel(payload.MessageID); {
+	case errors.Is(err, email.ErrMessageNotFound):
+		return protocol.NewError(req, protocol.CodeNotFound, "No status recorded for message "+payload.MessageID)
+	case errors.Is(err, email.ErrNotCancellable):
+		return protocol.NewError(req, protocol.CodeNotCancellable, err.Error())
+	}
+
+	log.Printf("🟡 Message %s cancelled by key %q", payload.MessageID, sess.KeyID)
+	status, _ := statuses.Get(payload.MessageID)
+	return protocol.NewResult(req, toStatusResult(status))
+}
+
+// canAccess reports whether the session may read or cancel a message. Keys
+// only see the messages they queued, so other keys' messages look unknown;
+// admin keys see every message.
+func (h *Handler) canAccess(sess *Session, status *email.MessageStatus) bool {
+	return status.KeyID == sess.KeyID || h.keys.Authorize(sess.KeyID, auth.ScopeAdmin) == nil
+}
+
+func toStatusResult(status *email.MessageStatus) protocol.StatusResult {
+	result := protocol.StatusResult{
+		MessageID: status.MessageID,
+		State:     status.State,
+		Reason:    status.Reason,
+		UpdatedAt: status.UpdatedAt,
+		History:   make([]protocol.StatusEvent, len(status.History)),
+	}
+	for i, event := range status.History {
+		result.History[i] = protocol.StatusEvent{State: event.State, Reason: event.Reason, At: event.At}
+	}
+	return result
+}
+
+// HandleMessage processes the legacy message shapes: {"secret": "..."} to
+// authenticate and a bare EmailData object to queue an email
+func (h *Handler) HandleMessage(sess *Session, message []byte) []byte {
+	// Sessions authenticated by a client certificate may still send the
+	// secret message, so it is recognised even after authentication
+	var authData protocol.AuthPayload
 	if err := json.Unmarshal(message, &authData); err == nil && authData.Secret != "" {
-		if authData.Secret == h.config.TCP.AuthSecret {
-			return createSuccessResponse("Authentication successful")
+		if h.plaintextAuthRejected(sess) {
+			sess.Close()
+			return createErrorResponse("Plaintext secrets are not accepted on this connection")
+		}
+		if h.authenticate(sess, authData.KeyID, authData.Secret) == nil {
+			sess.Close()
+			return createErrorResponse("Invalid authentication")
 		}
-		return createErrorResponse("Invalid authentication secret")
+		return createSuccessResponse("Authentication successful")
+	}
+
+	if !sess.Authenticated {
+		sess.Close()
+		return createErrorResponse("Authentication required")
+	}
+
+	if err := h.keys.Authorize(sess.KeyID, auth.ScopeSend); err != nil {
+		log.Printf("🔴 Key %q denied send from %s: %v", sess.KeyID, sess.RemoteAddr, err)
+		return createErrorResponse("Not allowed to send emails")
 	}
 
 	// Handle email message
@@ -41,14 +445,184 @@ func (h *Handler) HandleMessage(message []byte) []byte {
 		return createErrorResponse("Invalid email data format")
 	}
 
-	if err := h.emailService.QueueEmail(&emailData); err != nil {
+	if !h.isReplay(sess, &emailData) {
+		if retryAfter, ok := h.throttle(sess); !ok {
+			return createErrorResponse(fmt.Sprintf(busyMessage, retryAfter))
+		}
+	}
+
+	result, replayed, err := h.queueEmail(sess, &emailData)
+	if err != nil {
 		log.Printf("Error queueing email: %v", err)
 		metrics.EmailErrors.Inc()
 		return createErrorResponse("Failed to queue email")
 	}
 
-	metrics.EmailsQueued.Inc()
-	return createSuccessResponse("Email queued successfully")
+	if !replayed {
+		metrics.EmailsQueued.Inc()
+		metrics.EmailsQueuedByKey.WithLabelValues(sess.KeyID).Inc()
+		log.Printf("📨 Message %s queued by key %q", result.MessageID, sess.KeyID)
+	}
+	return createQueuedResponse(result.MessageID)
+}
+
+// plaintextAuthRejected reports whether a clear-text secret must be refused
+// because the connection is not encrypted and HMAC auth is required
+func (h *Handler) plaintextAuthRejected(sess *Session) bool {
+	if sess.TLS || sess.Local || !h.config.TCP.RequireHMACAuth {
+		return false
+	}
+	log.Printf("🔴 Rejected plaintext secret from %s on an unencrypted connection", sess.RemoteAddr)
+	return true
+}
+
+// authenticate checks a clear-text secret against the key store
+func (h *Handler) authenticate(sess *Session, keyID, secret string) *auth.Key {
+	if h.banned(sess) {
+		return nil
+	}
+	key, err := h.keys.Authenticate(keyID, secret)
+	return h.completeAuth(sess, keyID, key, err)
+}
+
+// authenticateChallenge checks a proof over the nonce issued to this session
+// and returns the server signature for the client to check. The nonce is
+// consumed whatever the outcome.
+func (h *Handler) authenticateChallenge(sess *Session, payload protocol.AuthPayload) (*auth.Key, string) {
+	nonce, keyID, expires := sess.challenge, sess.challengeKeyID, sess.challengeExpires
+	sess.challenge, sess.challengeKeyID = "", ""
+
+	if payload.KeyID == "" {
+		payload.KeyID = keyID
+	}
+	if nonce == "" || payload.Nonce != nonce || payload.KeyID != keyID || time.Now().After(expires) {
+		return h.completeAuth(sess, payload.KeyID, nil, errors.New("missing, mismatched or expired challenge")), ""
+	}
+
+	if h.banned(sess) {
+		return nil, ""
+	}
+	key, serverSignature, err := h.keys.VerifyChallenge(keyID, nonce, payload.Proof)
+	return h.completeAuth(sess, keyID, key, err), serverSignature
+}
+
+// AuthenticateCertificate maps a verified client certificate to an API key.
+// In "identity" mode the certificate authenticates the session on its own;
+// in "secret" mode the client must still authenticate with that key's secret.
+func (h *Handler) AuthenticateCertificate(sess *Session, cert *x509.Certificate) {
+	identities := auth.CertificateIdentities(cert)
+	key, err := h.keys.LookupCertificate(identities)
+	if err != nil {
+		metrics.TLSClientCertAuth.WithLabelValues("unmapped").Inc()
+		log.Printf("🟡 Client certificate %s from %s is not mapped to a usable key: %v", cert.Subject, sess.RemoteAddr, err)
+		return
+	}
+
+	sess.CertKeyID = key.ID
+	if h.config.TCP.TLS.ClientCertMode != "identity" {
+		metrics.TLSClientCertAuth.WithLabelValues("mapped").Inc()
+		log.Printf("🪪 Client certificate %s from %s mapped to key %q, secret still required", cert.Subject, sess.RemoteAddr, key.ID)
+		return
+	}
+
+	sess.Authenticated = true
+	sess.KeyID = key.ID
+	metrics.TLSClientCertAuth.WithLabelValues("authenticated").Inc()
+	metrics.TLSAuthSuccess.Inc()
+	log.Printf("🪪 %s authenticated as key %q by client certificate %s", sess.RemoteAddr, key.ID, cert.Subject)
+}
+
+// AuthenticatePeer maps the credentials of a Unix socket peer to an API key,
+// authenticating the session without a secret. Unmapped peers may still
+// authenticate with a secret.
+func (h *Handler) AuthenticatePeer(sess *Session, cred *PeerCredentials) {
+	key, err := h.keys.LookupPeer(cred.Identities())
+	if err != nil {
+		metrics.UnixPeerAuth.WithLabelValues("unmapped").Inc()
+		log.Printf("🟡 Unix socket peer %s (pid %d) is not mapped to a usable key: %v", sess.RemoteAddr, cred.PID, err)
+		return
+	}
+
+	sess.Authenticated = true
+	sess.KeyID = key.ID
+	metrics.UnixPeerAuth.WithLabelValues("authenticated").Inc()
+	log.Printf("🔌 %s (pid %d) authenticated as key %q by peer credentials", sess.RemoteAddr, cred.PID, key.ID)
+}
+
+// banned reports whether the client is banned. It is checked before any
+// secret is hashed, so banned clients cost no PBKDF2 work, and a ban issued
+// while this connection was open applies to it as well.
+func (h *Handler) banned(sess *Session) bool {
+	if err := h.guard.Check(sess.RemoteAddr); err != nil {
+		log.Printf("⛔ Authentication refused from %s: %v", sess.RemoteAddr, err)
+		return true
+	}
+	return false
+}
+
+// completeAuth records the outcome of an authentication attempt and marks the
+// session with the matching key. It returns nil when authentication failed.
+func (h *Handler) completeAuth(sess *Session, keyID string, key *auth.Key, err error) *auth.Key {
+	if err == nil && sess.CertKeyID != "" && key.ID != sess.CertKeyID {
+		err = fmt.Errorf("key %q does not match client certificate key %q", key.ID, sess.CertKeyID)
+	}
+	if err != nil {
+		if sess.TLS {
+			metrics.TLSAuthErrors.Inc()
+		} else {
+			metrics.TCPAuthErrors.Inc()
+		}
+		label := "unknown"
+		if _, ok := h.keys.Get(keyID); ok {
+			label = keyID
+		}
+		metrics.AuthFailuresByKey.WithLabelValues(label, authFailureReason(err)).Inc()
+		log.Printf("🔴 Authentication failed from %s (key %q): %v", sess.RemoteAddr, keyID, err)
+		h.guard.RecordFailure(sess.RemoteAddr)
+		return nil
+	}
+
+	sess.Authenticated = true
+	sess.KeyID = key.ID
+	if sess.TLS {
+		metrics.TLSAuthSuccess.Inc()
+	} else {
+		metrics.TCPAuthSuccess.Inc()
+	}
+	h.guard.RecordSuccess(sess.RemoteAddr)
+	log.Printf("🔑 %s authenticated as key %q", sess.RemoteAddr, key.ID)
+	return key
+}
+
+func authFailureReason(err error) string {
+	switch {
+	case errors.Is(err, auth.ErrKeyDisabled):
+		return "disabled"
+	case errors.Is(err, auth.ErrKeyExpired):
+		return "expired"
+	default:
+		return "invalid"
+	}
+}
+
+// queueErrorResponse maps an error from QueueEmail to a protocol error code
+func queueErrorResponse(req *protocol.Request, err error) *protocol.Response {
+	metrics.EmailErrors.Inc()
+
+	var validationErr *email.ValidationError
+	if errors.As(err, &validationErr) {
+		return protocol.NewError(req, protocol.CodeInvalidEmail, validationErr.Error())
+	}
+	var sizeErr *email.SizeError
+	if errors.As(err, &sizeErr) {
+		return protocol.NewError(req, protocol.CodeAttachmentTooLarge, sizeErr.Error())
+	}
+	if errors.Is(err, errIdempotencyConflict) {
+		return protocol.NewError(req, protocol.CodeIdempotencyConflict, err.Error())
+	}
+
+	log.Printf("Error queueing email: %v", err)
+	return protocol.NewError(req, protocol.CodeQueueFailed, "Failed to queue email")
 }
 
 func createErrorResponse(message string) []byte {
@@ -61,6 +635,18 @@ func createErrorResponse(message string) []byte {
 	return responseBytes
 }
 
+func createQueuedResponse(messageID string) []byte {
+	response := struct {
+		Message   string `json:"message"`
+		MessageID string `json:"message_id"`
+	}{
+		Message:   "Email queued successfully",
+		MessageID: messageID,
+	}
+	responseBytes, _ := json.Marshal(response)
+	return responseBytes
+}
+
 func createSuccessResponse(message string) []byte {
 	response := struct {
 		Message string `json:"message"`
@@ -69,4 +655,4 @@ func createSuccessResponse(message string) []byte {
 	}
 	responseBytes, _ := json.Marshal(response)
 	return responseBytes
-} 
\ No newline at end of file
+}
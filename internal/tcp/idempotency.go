@@ -0,0 +1,172 @@
+package tcp
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Arturstriker3/api-go/internal/email"
+	"github.com/Arturstriker3/api-go/internal/metrics"
+	"github.com/Arturstriker3/api-go/pkg/protocol"
+)
+
+// errIdempotencyConflict is returned when an idempotency key is reused with a
+// different email
+var errIdempotencyConflict = errors.New("idempotency key was already used with a different email")
+
+// idempotencyEntry is the outcome of the first request made with a key
+type idempotencyEntry struct {
+	hash [sha256.Size]byte
+	// done is closed once the first request has finished; result and ok are
+	// only read after that
+	done    chan struct{}
+	result  protocol.SendResult
+	ok      bool
+	expires time.Time
+}
+
+// idempotencyStore remembers the result of sends made with an idempotency
+// key, so a client retrying after a lost response gets the original message
+// ID instead of queueing the email again
+type idempotencyStore struct {
+	mu        sync.Mutex
+	window    time.Duration
+	entries   map[string]*idempotencyEntry
+	lastPrune time.Time
+}
+
+func newIdempotencyStore(window time.Duration) *idempotencyStore {
+	return &idempotencyStore{
+		window:    window,
+		entries:   make(map[string]*idempotencyEntry),
+		lastPrune: time.Now(),
+	}
+}
+
+// claim returns the entry for key. owner is true when the caller made the
+// first request and must queue the email and call finish.
+func (s *idempotencyStore) claim(key string, hash [sha256.Size]byte) (entry *idempotencyEntry, owner bool) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastPrune) > time.Minute {
+		s.prune(now)
+	}
+
+	if entry, ok := s.entries[key]; ok && (entry.expires.IsZero() || now.Before(entry.expires)) {
+		return entry, false
+	}
+	entry = &idempotencyEntry{hash: hash, done: make(chan struct{})}
+	s.entries[key] = entry
+	return entry, true
+}
+
+// settled returns the entry of a finished, successful request made with key,
+// or nil if there is none or it is still in flight. It never claims the key.
+func (s *idempotencyStore) settled(key string) *idempotencyEntry {
+	s.mu.Lock()
+	entry, ok := s.entries[key]
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	select {
+	case <-entry.done:
+	default:
+		return nil
+	}
+	if !entry.ok || time.Now().After(entry.expires) {
+		return nil
+	}
+	return entry
+}
+
+// finish records the outcome of the first request. A failed request releases
+// the key so the client can retry it.
+func (s *idempotencyStore) finish(key string, entry *idempotencyEntry, result protocol.SendResult, ok bool) {
+	s.mu.Lock()
+	if ok {
+		entry.result = result
+		entry.ok = true
+		entry.expires = time.Now().Add(s.window)
+	} else if s.entries[key] == entry {
+		delete(s.entries, key)
+	}
+	s.mu.Unlock()
+
+	close(entry.done)
+}
+
+// prune removes expired entries. The caller must hold the lock.
+func (s *idempotencyStore) prune(now time.Time) {
+	s.lastPrune = now
+	for key, entry := range s.entries {
+		if !entry.expires.IsZero() && now.After(entry.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// queueEmail queues an email, honouring its idempotency key. replayed is true
+// when the result comes from an earlier request with the same key.
+func (h *Handler) queueEmail(sess *Session, data *email.EmailData) (result protocol.SendResult, replayed bool, err error) {
+	if data.IdempotencyKey == "" || h.idempotency == nil {
+		messageID, err := h.emailService.QueueEmail(data, sess.KeyID)
+		return protocol.SendResult{MessageID: messageID, Status: email.StateQueued}, false, err
+	}
+
+	key := idempotencyKey(sess, data)
+	hash := emailHash(data)
+
+	for {
+		entry, owner := h.idempotency.claim(key, hash)
+		if owner {
+			messageID, err := h.emailService.QueueEmail(data, sess.KeyID)
+			result = protocol.SendResult{MessageID: messageID, Status: email.StateQueued}
+			h.idempotency.finish(key, entry, result, err == nil)
+			return result, false, err
+		}
+
+		if entry.hash != hash {
+			return protocol.SendResult{}, false, errIdempotencyConflict
+		}
+		<-entry.done
+		if entry.ok {
+			metrics.IdempotentReplays.Inc()
+			result = entry.result
+			result.Replayed = true
+			return result, true, nil
+		}
+		// The first request failed and released the key; try again
+	}
+}
+
+// isReplay reports whether an email is answered from an earlier send with the
+// same idempotency key, either with its result or with a conflict. Such
+// requests queue nothing, so backpressure does not apply to them.
+func (h *Handler) isReplay(sess *Session, data *email.EmailData) bool {
+	if data.IdempotencyKey == "" || h.idempotency == nil {
+		return false
+	}
+	return h.idempotency.settled(idempotencyKey(sess, data)) != nil
+}
+
+// idempotencyKey scopes an idempotency key to the API key, so clients cannot
+// collide
+func idempotencyKey(sess *Session, data *email.EmailData) string {
+	return sess.KeyID + "\x00" + data.IdempotencyKey
+}
+
+// emailHash fingerprints the email a key was first used with
+func emailHash(data *email.EmailData) [sha256.Size]byte {
+	fingerprint := *data
+	fingerprint.MessageID = ""
+	fingerprint.QueuedAt = time.Time{}
+	encoded, _ := json.Marshal(fingerprint)
+	return sha256.Sum256(encoded)
+}
@@ -0,0 +1,133 @@
+package tcp
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Arturstriker3/api-go/internal/auth"
+	"github.com/Arturstriker3/api-go/internal/metrics"
+)
+
+// Reasons for refusing a connection, used as metric labels
+const (
+	rejectDraining       = "draining"
+	RejectMaxConnections = "max_connections"
+	RejectMaxPerIP       = "max_connections_per_ip"
+	rejectProxyHeader    = "proxy_header"
+)
+
+// ConnLimiter caps the connections open on a server, in total and per client
+// IP. A limit of 0 means unlimited.
+type ConnLimiter struct {
+	maxConns int
+	maxPerIP int
+
+	mu   sync.Mutex
+	open int
+	byIP map[string]int
+}
+
+// NewConnLimiter creates a limiter for maxConns connections in total and
+// maxPerIP from any one client IP
+func NewConnLimiter(maxConns, maxPerIP int) *ConnLimiter {
+	return &ConnLimiter{
+		maxConns: maxConns,
+		maxPerIP: maxPerIP,
+		byIP:     make(map[string]int),
+	}
+}
+
+// Acquire reserves a slot for a connection from addr. It returns which limit
+// refuses the connection, or "" when it was accepted and must be released.
+func (l *ConnLimiter) Acquire(addr string) string {
+	ip := auth.HostIP(addr)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch {
+	case l.maxConns > 0 && l.open >= l.maxConns:
+		return RejectMaxConnections
+	case l.maxPerIP > 0 && l.byIP[ip] >= l.maxPerIP:
+		return RejectMaxPerIP
+	}
+	l.open++
+	l.byIP[ip]++
+	return ""
+}
+
+// Release frees the slot of a connection from addr
+func (l *ConnLimiter) Release(addr string) {
+	ip := auth.HostIP(addr)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.open--
+	if l.byIP[ip]--; l.byIP[ip] <= 0 {
+		delete(l.byIP, ip)
+	}
+}
+
+// trackConn registers a new connection. It returns why the connection must be
+// refused, or "" when it was accepted.
+func (s *Server) trackConn(conn net.Conn) string {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+
+	if s.draining.Load() {
+		return rejectDraining
+	}
+	if reason := s.limiter.Acquire(conn.RemoteAddr().String()); reason != "" {
+		return reason
+	}
+
+	s.conns[conn] = struct{}{}
+	s.connsWG.Add(1)
+	metrics.TCPConnectionsOpen.Set(float64(len(s.conns)))
+	return ""
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.connsMu.Lock()
+	delete(s.conns, conn)
+	s.limiter.Release(conn.RemoteAddr().String())
+	metrics.TCPConnectionsOpen.Set(float64(len(s.conns)))
+	s.connsMu.Unlock()
+
+	s.connsWG.Done()
+}
+
+// setReadDeadline bounds the wait for the next request: unauthenticated
+// sessions must authenticate within the auth timeout of connecting, and every
+// session must send a request within the idle timeout. It returns which of
+// the two applies, for reporting.
+func (s *Server) setReadDeadline(conn net.Conn, sess *Session, connectedAt time.Time) string {
+	var deadline time.Time
+	stage := "idle"
+
+	if s.config.TCP.IdleTimeout > 0 {
+		deadline = time.Now().Add(s.config.TCP.IdleTimeout)
+	}
+	if !sess.Authenticated && s.config.TCP.AuthTimeout > 0 {
+		authDeadline := connectedAt.Add(s.config.TCP.AuthTimeout)
+		if deadline.IsZero() || authDeadline.Before(deadline) {
+			deadline = authDeadline
+			stage = "auth"
+		}
+	}
+	conn.SetReadDeadline(deadline)
+
+	// Shutdown may have set an immediate deadline that was just overwritten
+	if s.draining.Load() {
+		conn.SetReadDeadline(time.Now())
+	}
+	return stage
+}
+
+func isTimeout(err error) bool {
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}
@@ -0,0 +1,182 @@
+package tcp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// errCertificateRevoked is returned by the handshake for revoked client
+// certificates
+var errCertificateRevoked = errors.New("certificate has been revoked")
+
+// revocationList holds the serial numbers revoked by the configured CRL
+type revocationList struct {
+	mu      sync.RWMutex
+	serials map[string]struct{}
+}
+
+func (r *revocationList) isRevoked(cert *x509.Certificate) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, revoked := r.serials[cert.SerialNumber.String()]
+	return revoked
+}
+
+func (r *revocationList) replace(serials map[string]struct{}) {
+	r.mu.Lock()
+	r.serials = serials
+	r.mu.Unlock()
+}
+
+// clientAuthType maps TCP_TLS_CLIENT_AUTH to the crypto/tls setting
+func clientAuthType(mode string) tls.ClientAuthType {
+	switch mode {
+	case "required":
+		return tls.RequireAndVerifyClientCert
+	case "optional":
+		return tls.VerifyClientCertIfGiven
+	default:
+		return tls.NoClientCert
+	}
+}
+
+// configureClientAuth enables client certificate verification on the TLS
+// config when mutual TLS is turned on
+func (s *Server) configureClientAuth(tlsConfig *tls.Config) error {
+	tlsCfg := s.config.TCP.TLS
+	tlsConfig.ClientAuth = clientAuthType(tlsCfg.ClientAuth)
+	if tlsConfig.ClientAuth == tls.NoClientCert {
+		return nil
+	}
+
+	caCerts, err := loadCertificates(tlsCfg.CAPath)
+	if err != nil {
+		return fmt.Errorf("failed to load client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	for _, cert := range caCerts {
+		pool.AddCert(cert)
+	}
+	tlsConfig.ClientCAs = pool
+
+	if tlsCfg.CRLPath != "" {
+		if err := s.loadCRL(caCerts); err != nil {
+			return err
+		}
+		tlsConfig.VerifyPeerCertificate = s.verifyNotRevoked
+	}
+
+	log.Printf("🪪 Mutual TLS enabled (%s) - client certificates verified against %s", tlsCfg.ClientAuth, tlsCfg.CAPath)
+	return nil
+}
+
+// loadCRL reads the revocation list and checks it was issued by the CA
+func (s *Server) loadCRL(caCerts []*x509.Certificate) error {
+	data, err := os.ReadFile(s.config.TCP.TLS.CRLPath)
+	if err != nil {
+		return fmt.Errorf("failed to read CRL: %w", err)
+	}
+
+	// Accept both PEM (possibly several lists) and a single DER list
+	var ders [][]byte
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "X509 CRL" {
+			ders = append(ders, block.Bytes)
+		}
+	}
+	if len(ders) == 0 {
+		ders = [][]byte{data}
+	}
+
+	serials := make(map[string]struct{})
+	for _, der := range ders {
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return fmt.Errorf("failed to parse CRL: %w", err)
+		}
+		if !signedByAny(crl, caCerts) {
+			return fmt.Errorf("CRL %s is not signed by the configured CA", s.config.TCP.TLS.CRLPath)
+		}
+		for _, entry := range crl.RevokedCertificateEntries {
+			serials[entry.SerialNumber.String()] = struct{}{}
+		}
+	}
+
+	s.revoked.replace(serials)
+	log.Printf("📛 Loaded CRL with %d revoked certificates", len(serials))
+	return nil
+}
+
+// verifyNotRevoked rejects client certificate chains containing a revoked
+// certificate. It runs after the standard chain verification.
+func (s *Server) verifyNotRevoked(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+	for _, chain := range verifiedChains {
+		for _, cert := range chain {
+			if s.revoked.isRevoked(cert) {
+				return fmt.Errorf("%w: %s (serial %s)", errCertificateRevoked, cert.Subject, cert.SerialNumber)
+			}
+		}
+	}
+	return nil
+}
+
+// isClientCertificateError reports whether a handshake failed because the
+// client certificate was missing, untrusted or revoked, as opposed to network
+// noise such as health checks
+func isClientCertificateError(err error) bool {
+	var verifyErr *tls.CertificateVerificationError
+	return errors.As(err, &verifyErr) ||
+		errors.Is(err, errCertificateRevoked) ||
+		strings.Contains(err.Error(), "client didn't provide a certificate")
+}
+
+func signedByAny(crl *x509.RevocationList, issuers []*x509.Certificate) bool {
+	for _, issuer := range issuers {
+		if crl.CheckSignatureFrom(issuer) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// loadCertificates parses every PEM certificate in the file
+func loadCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return certs, nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package tcp
+
+import "net"
+
+// readPeerCredentials is only implemented on Linux; elsewhere Unix socket
+// clients authenticate with a secret like TCP clients
+func readPeerCredentials(conn *net.UnixConn) (*PeerCredentials, error) {
+	return nil, errPeerCredentialsUnsupported
+}
@@ -0,0 +1,204 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyV2Signature starts every PROXY protocol v2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// A v1 header is at most 107 bytes including the CRLF
+const proxyV1MaxLength = 107
+
+var errNoProxyHeader = errors.New("missing PROXY protocol header")
+
+// proxyListener wraps accepted connections from trusted sources so their
+// PROXY protocol header is consumed and the client address it carries
+// replaces the load balancer's
+type proxyListener struct {
+	net.Listener
+	trusted []*net.IPNet
+	timeout time.Duration
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || !ipInNets(l.trusted, addr.IP) {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn), timeout: l.timeout}, nil
+}
+
+// proxyConn reads the PROXY header lazily, on the first Read or address
+// lookup, so a slow load balancer never blocks the accept loop
+type proxyConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	err    error
+	remote net.Addr
+	local  net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	if err := c.readHeader(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.readHeader() == nil && c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.readHeader() == nil && c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *proxyConn) readHeader() error {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+
+		signature, err := c.reader.Peek(len(proxyV2Signature))
+		switch {
+		case err != nil:
+			c.err = fmt.Errorf("%w: %v", errNoProxyHeader, err)
+		case bytes.Equal(signature, proxyV2Signature):
+			c.err = c.readV2()
+		case bytes.HasPrefix(signature, []byte("PROXY ")):
+			c.err = c.readV1()
+		default:
+			c.err = errNoProxyHeader
+		}
+	})
+	return c.err
+}
+
+// readV1 parses "PROXY TCP4 <src> <dst> <src port> <dst port>\r\n". The
+// UNKNOWN protocol keeps the connection's own addresses.
+func (c *proxyConn) readV1() error {
+	line, err := c.reader.ReadSlice('\n')
+	if err != nil || len(line) > proxyV1MaxLength || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return errors.New("malformed PROXY v1 header")
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return fmt.Errorf("malformed PROXY v1 header %q", strings.TrimSpace(string(line)))
+	}
+
+	src, err := parseProxyAddr(fields[2], fields[4])
+	if err != nil {
+		return err
+	}
+	dst, err := parseProxyAddr(fields[3], fields[5])
+	if err != nil {
+		return err
+	}
+	c.remote, c.local = src, dst
+	return nil
+}
+
+// readV2 parses the binary header. LOCAL commands (health checks from the
+// balancer itself) and non-TCP families keep the connection's own addresses.
+func (c *proxyConn) readV2() error {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return fmt.Errorf("malformed PROXY v2 header: %w", err)
+	}
+	if header[12]>>4 != 2 {
+		return fmt.Errorf("unsupported PROXY protocol version %d", header[12]>>4)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return fmt.Errorf("malformed PROXY v2 header: %w", err)
+	}
+
+	command, family := header[12]&0x0f, header[13]
+	if command == 0x0 {
+		return nil
+	}
+	if command != 0x1 {
+		return fmt.Errorf("unsupported PROXY v2 command %d", command)
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return errors.New("short PROXY v2 IPv4 address block")
+		}
+		c.remote = &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}
+		c.local = &net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:12]))}
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return errors.New("short PROXY v2 IPv6 address block")
+		}
+		c.remote = &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}
+		c.local = &net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:36]))}
+	}
+	return nil
+}
+
+func parseProxyAddr(host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid PROXY address %q", host)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 0 || p > 65535 {
+		return nil, fmt.Errorf("invalid PROXY port %q", port)
+	}
+	return &net.TCPAddr{IP: ip, Port: p}, nil
+}
+
+// readProxyHeader consumes the PROXY header of a connection from a trusted
+// source; other connections are left untouched
+func readProxyHeader(conn net.Conn) error {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	if pc, ok := conn.(*proxyConn); ok {
+		return pc.readHeader()
+	}
+	return nil
+}
+
+func ipInNets(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
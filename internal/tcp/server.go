@@ -1,151 +1,345 @@
 package tcp
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Arturstriker3/api-go/config"
-	"github.com/Arturstriker3/api-go/internal/email"
+	"github.com/Arturstriker3/api-go/internal/auth"
 	"github.com/Arturstriker3/api-go/internal/metrics"
+	"github.com/Arturstriker3/api-go/pkg/protocol"
 )
 
 type Server struct {
 	config      *config.Config
-	listener    net.Listener
+	listeners   []net.Listener
+	listenersMu sync.Mutex
 	handler     *Handler
-	authSecret  string
 	tlsConfig   *tls.Config
+	tlsOnce     sync.Once
+	tlsErr      error
+	certificate *tls.Certificate
 	certMutex   sync.RWMutex
-}
+	revoked     revocationList
+	// proxyTrusted are the sources expected to send a PROXY header
+	proxyTrusted []*net.IPNet
 
-func NewServer(cfg *config.Config, emailService *email.Service) (*Server, error) {
-	handler := NewHandler(cfg, emailService)
-	return &Server{
-		config:     cfg,
-		handler:    handler,
-		authSecret: cfg.TCP.AuthSecret,
-	}, nil
+	// Open connections, tracked so Shutdown can drain them
+	connsMu  sync.Mutex
+	conns    map[net.Conn]struct{}
+	limiter  *ConnLimiter
+	connsWG  sync.WaitGroup
+	draining atomic.Bool
 }
 
-func (s *Server) Start() error {
-	// Check if any TCP service is enabled
-	if !s.config.TCP.Enabled && !s.config.TCP.TLS.Enabled {
-		return fmt.Errorf("🔴 Both TCP and TLS are disabled. Enable at least one with TCP_ENABLED=true or TCP_TLS_ENABLED=true")
+func NewServer(cfg *config.Config, handler *Handler) (*Server, error) {
+	server := &Server{
+		config:  cfg,
+		handler: handler,
+		conns:   make(map[net.Conn]struct{}),
+		limiter: NewConnLimiter(cfg.TCP.MaxConnections, cfg.TCP.MaxConnectionsPerIP),
 	}
 
-	var listener net.Listener
-	var err error
-	
-	address := fmt.Sprintf(":%s", s.config.TCP.Port)
-	
-	// Determine which mode to use based on configuration
-	if s.config.TCP.TLS.Enabled && s.config.TCP.Enabled {
-		// Both enabled - prioritize TLS for security
-		log.Printf("🟡 Both TCP and TLS are enabled. Using TLS for security (TCP_ENABLED will be ignored)")
-		log.Printf("💡 Set TCP_ENABLED=false to disable insecure TCP completely")
-	}
-	
-	if s.config.TCP.TLS.Enabled {
-		// Load TLS certificates
-		cert, err := tls.LoadX509KeyPair(s.config.TCP.TLS.CertPath, s.config.TCP.TLS.KeyPath)
+	if cfg.TCP.ProxyProtocol {
+		trusted, err := auth.ParseCIDRs(cfg.TCP.ProxyTrustedCIDRs)
 		if err != nil {
-			return fmt.Errorf("failed to load TLS certificates: %w", err)
+			return nil, fmt.Errorf("invalid TCP_PROXY_TRUSTED_CIDRS: %w", err)
 		}
+		server.proxyTrusted = trusted
+	}
+	return server, nil
+}
 
-		// Check certificate expiry
-		if len(cert.Certificate) > 0 {
-			x509Cert, err := x509.ParseCertificate(cert.Certificate[0])
-			if err == nil {
-				daysUntilExpiry := time.Until(x509Cert.NotAfter).Hours() / 24
-				metrics.TLSCertificateExpiry.Set(daysUntilExpiry)
-				log.Printf("📅 Certificate expires in %.0f days (%s)", daysUntilExpiry, x509Cert.NotAfter.Format("2006-01-02"))
+// Start opens every configured listener and serves them until Stop is
+// called. All listeners share the same handler.
+func (s *Server) Start() error {
+	if len(s.config.TCP.Listeners) == 0 {
+		return fmt.Errorf("🔴 Both TCP and TLS are disabled. Enable at least one with TCP_ENABLED=true or TCP_TLS_ENABLED=true, or set TCP_LISTENERS")
+	}
+
+	hasPlain := false
+	for _, lc := range s.config.TCP.Listeners {
+		if !lc.TLS && !lc.Unix {
+			hasPlain = true
+		}
+		if lc.TLS {
+			if _, err := s.TLSConfig(); err != nil {
+				return err
 			}
 		}
+	}
+	if s.config.TCP.TLS.Enabled && s.config.TCP.Enabled && !hasPlain {
+		log.Printf("🟡 Both TCP and TLS are enabled but only TLS is listening on port %s", s.config.TCP.Port)
+		log.Printf("💡 Set TCP_PLAIN_PORT (or TCP_LISTENERS) to also serve plain TCP, or TCP_ENABLED=false to silence this warning")
+	}
 
-		tlsConfig := &tls.Config{
-			Certificates: []tls.Certificate{cert},
-			ServerName:   "localhost", // For development
+	for _, lc := range s.config.TCP.Listeners {
+		listener, err := s.listen(lc)
+		if err != nil {
+			s.Stop()
+			return err
 		}
+		s.listenersMu.Lock()
+		s.listeners = append(s.listeners, listener)
+		s.listenersMu.Unlock()
+	}
+
+	var wg sync.WaitGroup
+	s.listenersMu.Lock()
+	for i, listener := range s.listeners {
+		wg.Add(1)
+		go func(listener net.Listener, lc config.ListenerConfig) {
+			defer wg.Done()
+			s.serve(listener, lc)
+		}(listener, s.config.TCP.Listeners[i])
+	}
+	s.listenersMu.Unlock()
 
-		s.tlsConfig = tlsConfig // Store reference for hot reload
+	wg.Wait()
+	return nil
+}
 
-		listener, err = tls.Listen("tcp", address, tlsConfig)
-		if err != nil {
-			return fmt.Errorf("failed to start TLS server: %w", err)
+// TLSConfig returns the TLS configuration shared by every TLS listener,
+// loading it on first use. Other servers may use it to present the same
+// certificate, with the same hot reload and client certificate policy.
+func (s *Server) TLSConfig() (*tls.Config, error) {
+	s.tlsOnce.Do(func() {
+		s.tlsErr = s.loadTLSConfig()
+	})
+	return s.tlsConfig, s.tlsErr
+}
+
+// loadTLSConfig builds the TLS configuration shared by every TLS listener
+func (s *Server) loadTLSConfig() error {
+	// Load TLS certificates
+	cert, err := tls.LoadX509KeyPair(s.config.TCP.TLS.CertPath, s.config.TCP.TLS.KeyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificates: %w", err)
+	}
+
+	// Check certificate expiry
+	if len(cert.Certificate) > 0 {
+		x509Cert, err := x509.ParseCertificate(cert.Certificate[0])
+		if err == nil {
+			daysUntilExpiry := time.Until(x509Cert.NotAfter).Hours() / 24
+			metrics.TLSCertificateExpiry.Set(daysUntilExpiry)
+			log.Printf("📅 Certificate expires in %.0f days (%s)", daysUntilExpiry, x509Cert.NotAfter.Format("2006-01-02"))
 		}
-		
-		log.Printf("🔒 TLS Server listening on port %s (SECURE)", s.config.TCP.Port)
-		log.Printf("📜 Using certificate: %s", s.config.TCP.TLS.CertPath)
-		log.Printf("🛡️  All connections will be encrypted")
-		
-		// Start certificate watcher for hot reload
-		s.StartCertificateWatcher()
-	} else if s.config.TCP.Enabled {
-		listener, err = net.Listen("tcp", address)
-		if err != nil {
-			return fmt.Errorf("failed to start TCP server: %w", err)
+	}
+
+	s.certificate = &cert
+
+	// The certificate is looked up on every handshake so a reload applies to
+	// every server sharing this config
+	tlsConfig := &tls.Config{
+		GetCertificate: s.getCertificate,
+		ServerName:     "localhost", // For development
+	}
+
+	if err := s.configureClientAuth(tlsConfig); err != nil {
+		return err
+	}
+
+	s.tlsConfig = tlsConfig // Store reference for hot reload
+	log.Printf("📜 Using certificate: %s", s.config.TCP.TLS.CertPath)
+
+	// Start certificate watcher for hot reload
+	s.StartCertificateWatcher()
+	return nil
+}
+
+func (s *Server) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.certMutex.RLock()
+	defer s.certMutex.RUnlock()
+	return s.certificate, nil
+}
+
+// listen opens one configured listener. The PROXY header comes before the
+// TLS handshake, so TLS wraps the PROXY protocol listener.
+func (s *Server) listen(lc config.ListenerConfig) (net.Listener, error) {
+	if lc.Unix {
+		return s.listenUnix(lc)
+	}
+
+	listener, err := net.Listen("tcp", lc.Address)
+	if err != nil {
+		if lc.TLS {
+			return nil, fmt.Errorf("failed to start TLS listener %s: %w", lc, err)
 		}
-		
-		log.Printf("🟡 TCP Server listening on port %s (INSECURE)", s.config.TCP.Port)
+		return nil, fmt.Errorf("failed to start TCP listener %s: %w", lc, err)
+	}
+	if s.proxyTrusted != nil {
+		listener = &proxyListener{Listener: listener, trusted: s.proxyTrusted, timeout: s.config.TCP.HandshakeTimeout}
+		log.Printf("🔀 PROXY protocol expected on %s from %s", lc.Address, s.config.TCP.ProxyTrustedCIDRs)
+	}
+
+	if lc.TLS {
+		log.Printf("🔒 TLS listener on %s (SECURE)", lc.Address)
+		return tls.NewListener(listener, s.tlsConfig), nil
+	}
+
+	log.Printf("🟡 TCP listener on %s (INSECURE)", lc.Address)
+	if !s.config.TCP.TLS.Enabled {
 		log.Printf("💡 Consider enabling TLS with TCP_TLS_ENABLED=true")
-		log.Printf("🔒 For production, set TCP_ENABLED=false and TCP_TLS_ENABLED=true")
 	}
-	
-	s.listener = listener
+	return listener, nil
+}
 
+// serve accepts connections on one listener until it is closed
+func (s *Server) serve(listener net.Listener, lc config.ListenerConfig) {
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			log.Printf("Error accepting connection: %v", err)
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("Error accepting connection on %s: %v", lc, err)
 			metrics.TCPErrors.Inc()
 			continue
 		}
 
-		// Log connection type and update metrics
-		if s.config.TCP.TLS.Enabled {
-			if tlsConn, ok := conn.(*tls.Conn); ok {
-				// Don't log or count until handshake succeeds
-				go s.handleTLSConnection(tlsConn)
-			}
+		go s.accept(conn)
+	}
+}
+
+// accept applies the connection checks and serves the connection. It runs on
+// the connection's goroutine because reading a PROXY header may block.
+func (s *Server) accept(conn net.Conn) {
+	if err := readProxyHeader(conn); err != nil {
+		metrics.TCPConnectionsRejected.WithLabelValues(rejectProxyHeader).Inc()
+		log.Printf("🔴 Refused connection from %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	if !s.handler.AllowConnection(conn.RemoteAddr().String()) {
+		conn.Close()
+		return
+	}
+	if reason := s.trackConn(conn); reason != "" {
+		if reason != rejectDraining {
+			metrics.TCPConnectionsRejected.WithLabelValues(reason).Inc()
+			log.Printf("🟡 Refused connection from %s: %s reached", conn.RemoteAddr(), reason)
+		}
+		conn.Close()
+		return
+	}
+	defer s.untrackConn(conn)
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		// Don't log or count until handshake succeeds
+		s.handleTLSConnection(tlsConn)
+		return
+	}
+
+	if pc, ok := conn.(*peerConn); ok {
+		if pc.cred != nil {
+			log.Printf("🔌 New Unix socket connection from %s, pid %d", conn.RemoteAddr(), pc.cred.PID)
 		} else {
-			log.Printf("🟡 New insecure TCP connection from %s", conn.RemoteAddr())
-			
-			// Update TCP metrics
-			metrics.TCPConnections.Inc()
-			defer metrics.TCPConnections.Dec()
-			
-			go s.handleConnection(conn, false)
+			log.Printf("🔌 New Unix socket connection")
+		}
+		s.handleConnection(conn, false)
+		return
+	}
+
+	log.Printf("🟡 New insecure TCP connection from %s", conn.RemoteAddr())
+
+	// Update TCP metrics
+	metrics.TCPConnections.Inc()
+	defer metrics.TCPConnections.Dec()
+
+	s.handleConnection(conn, false)
+}
+
+// Shutdown stops accepting connections and lets every open connection finish
+// the request it is handling. Connections waiting for their next request are
+// closed right away; any still open when ctx expires are closed forcibly.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.connsMu.Lock()
+	s.draining.Store(true)
+	s.connsMu.Unlock()
+
+	if err := s.Stop(); err != nil {
+		log.Printf("🔴 Error closing listeners: %v", err)
+	}
+
+	// Wake up connections blocked reading their next request. Those busy with
+	// a request notice the drain after writing the response.
+	s.connsMu.Lock()
+	open := len(s.conns)
+	for conn := range s.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	s.connsMu.Unlock()
+	log.Printf("🟡 Draining %d TCP connection(s)", open)
+
+	drained := make(chan struct{})
+	go func() {
+		s.connsWG.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		log.Println("✅ TCP connections drained")
+		return nil
+	case <-ctx.Done():
+		s.connsMu.Lock()
+		for conn := range s.conns {
+			conn.Close()
 		}
+		s.connsMu.Unlock()
+		return fmt.Errorf("forced %d connection(s) closed: %w", open, ctx.Err())
 	}
 }
 
+// Stop closes every listener; connections already open are left to finish
 func (s *Server) Stop() error {
-	if s.listener != nil {
-		return s.listener.Close()
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+
+	var firstErr error
+	for _, listener := range s.listeners {
+		if err := listener.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
-	return nil
+	return firstErr
 }
 
 func (s *Server) handleTLSConnection(tlsConn *tls.Conn) {
 	defer tlsConn.Close()
 	
 	// Perform TLS handshake first
+	if s.config.TCP.HandshakeTimeout > 0 {
+		tlsConn.SetDeadline(time.Now().Add(s.config.TCP.HandshakeTimeout))
+	}
 	if err := tlsConn.Handshake(); err != nil {
 		// Only log handshake failures if they're not from health checks
 		errStr := err.Error()
-		if !isHealthCheckError(errStr) {
+		if isTimeout(err) {
+			metrics.TCPTimeouts.WithLabelValues("handshake").Inc()
+			log.Printf("⏱️ TLS handshake from %s timed out", tlsConn.RemoteAddr())
+		} else if isClientCertificateError(err) {
+			s.handler.RecordCertificateFailure(tlsConn.RemoteAddr().String(), err)
+		} else if !isHealthCheckError(errStr) {
 			log.Printf("🔴 TLS handshake failed from %s: %v", tlsConn.RemoteAddr(), err)
 		}
 		return
 	}
+	tlsConn.SetDeadline(time.Time{})
 	
 	// Log and count only successful TLS connections
 	log.Printf("🔒 TLS connection established from %s", tlsConn.RemoteAddr())
@@ -154,6 +348,9 @@ func (s *Server) handleTLSConnection(tlsConn *tls.Conn) {
 	state := tlsConn.ConnectionState()
 	log.Printf("   Cipher Suite: %s", tls.CipherSuiteName(state.CipherSuite))
 	log.Printf("   TLS Version: %x", state.Version)
+	if len(state.PeerCertificates) > 0 {
+		log.Printf("   Client Certificate: %s", state.PeerCertificates[0].Subject)
+	}
 	
 	// Update TLS metrics only for successful connections
 	metrics.TLSConnections.Inc()
@@ -182,80 +379,116 @@ func isHealthCheckError(errStr string) bool {
 func (s *Server) handleConnection(conn net.Conn, isTLS bool) {
 	defer conn.Close()
 	
-	authenticated := false
+	sess := NewSession(conn.RemoteAddr().String(), isTLS)
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			s.handler.AuthenticateCertificate(sess, certs[0])
+		}
+	}
+	if pc, ok := conn.(*peerConn); ok {
+		sess.Local = true
+		if pc.cred != nil {
+			s.handler.AuthenticatePeer(sess, pc.cred)
+		}
+	}
+
+	connectedAt := time.Now()
+	framer := protocol.NewFramer(conn, protocol.FramingNewline, s.config.TCP.MaxFrameSize)
+
+	stage := s.setReadDeadline(conn, sess, connectedAt)
+	if _, err := framer.DetectFraming(); err != nil {
+		s.logReadError(conn, err, stage)
+		return
+	}
 
 	for {
-		buffer := make([]byte, 4096)
-		n, err := conn.Read(buffer)
+		if s.draining.Load() {
+			return
+		}
+
+		framer.SetMaxFrameSize(s.frameLimit(sess))
+		message, err := framer.ReadFrame()
 		if err != nil {
-			if err.Error() != "EOF" {
-				log.Printf("🔴 Error reading message: %v", err)
+			if errors.Is(err, protocol.ErrFrameTooLarge) {
+				log.Printf("🔴 Frame from %s exceeds %d bytes, closing connection", conn.RemoteAddr(), framer.MaxFrameSize())
+				sendFrameTooLarge(framer)
+			} else {
+				s.logReadError(conn, err, stage)
 			}
 			return
 		}
 
-		message := buffer[:n]
-		
-		if !authenticated {
-			// Primeiro, tentar autenticar
-			var auth struct {
-				Secret string `json:"secret"`
-			}
-			if err := json.Unmarshal(message, &auth); err == nil && auth.Secret != "" {
-				if auth.Secret != s.authSecret {
-					if isTLS {
-						// TLS auth error - no specific metric for now
-					} else {
-						metrics.TCPAuthErrors.Inc()
-					}
-					sendError(conn, "Invalid authentication")
-					return
-				}
-				
-				authenticated = true
-				if isTLS {
-					// TLS auth success - no specific metric for now
-				} else {
-					metrics.TCPAuthSuccess.Inc()
-				}
-				sendSuccess(conn, "Authentication successful")
-				continue
+		response := s.handler.HandleFrame(sess, message)
+		if s.config.TCP.WriteTimeout > 0 {
+			conn.SetWriteDeadline(time.Now().Add(s.config.TCP.WriteTimeout))
+		}
+		if err := framer.WriteFrame(response); err != nil {
+			if isTimeout(err) {
+				metrics.TCPTimeouts.WithLabelValues("write").Inc()
+				log.Printf("⏱️ %s is not reading its responses, closing connection", conn.RemoteAddr())
+			} else {
+				log.Printf("🔴 Error writing response: %v", err)
 			}
-			
-			// Se não é autenticação, rejeitar
-			sendError(conn, "Authentication required")
 			return
 		}
 
-		// Se já autenticado, processar mensagem de email
-		response := s.handler.HandleMessage(message)
-		conn.Write(response)
+		if sess.Closing() {
+			return
+		}
+
+		// Switch framing only after the HELLO response went out in the old one
+		if sess.Framing != "" && sess.Framing != framer.Framing() {
+			framer.SetFraming(sess.Framing)
+		}
+
+		stage = s.setReadDeadline(conn, sess, connectedAt)
 	}
 }
 
-func sendError(conn net.Conn, message string) {
-	response := struct {
-		Error string `json:"error"`
-	}{
-		Error: message,
+// frameLimit is the largest frame accepted from the session: small enough for
+// HELLO and AUTH until it authenticates, TCP_MAX_FRAME_SIZE afterwards
+func (s *Server) frameLimit(sess *Session) int {
+	maxFrameSize := s.config.TCP.MaxFrameSize
+	if maxFrameSize <= 0 {
+		maxFrameSize = protocol.DefaultMaxFrameSize
+	}
+	if sess.Authenticated {
+		return maxFrameSize
+	}
+	return min(s.config.TCP.MaxPreAuthFrameSize, maxFrameSize)
+}
+
+// logReadError reports why reading a request failed. Timeouts and clients
+// hanging up are expected and only counted.
+func (s *Server) logReadError(conn net.Conn, err error, stage string) {
+	switch {
+	case s.draining.Load() || err == io.EOF:
+	case isTimeout(err):
+		metrics.TCPTimeouts.WithLabelValues(stage).Inc()
+		log.Printf("⏱️ Closing connection from %s: %s timeout", conn.RemoteAddr(), stage)
+	default:
+		log.Printf("🔴 Error reading message: %v", err)
 	}
-	responseBytes, _ := json.Marshal(response)
-	conn.Write(responseBytes)
 }
 
-func sendSuccess(conn net.Conn, message string) {
+// sendFrameTooLarge reports an oversized frame. The request could not be
+// decoded, so the response has no request ID; legacy clients only check that
+// "error" is set.
+func sendFrameTooLarge(framer *protocol.Framer) {
 	response := struct {
-		Message string `json:"message"`
+		OK    bool            `json:"ok"`
+		Error *protocol.Error `json:"error"`
 	}{
-		Message: message,
+		Error: &protocol.Error{Code: protocol.CodeFrameTooLarge, Message: "Message too large"},
 	}
 	responseBytes, _ := json.Marshal(response)
-	conn.Write(responseBytes)
-} 
+	framer.WriteFrame(responseBytes)
+}
 
 // ReloadCertificates reloads TLS certificates without restarting the server
 func (s *Server) ReloadCertificates() error {
-	if !s.config.TCP.TLS.Enabled {
+	if s.tlsConfig == nil {
 		return fmt.Errorf("TLS is not enabled")
 	}
 
@@ -277,16 +510,27 @@ func (s *Server) ReloadCertificates() error {
 
 	// Thread-safe certificate update
 	s.certMutex.Lock()
-	s.tlsConfig.Certificates = []tls.Certificate{cert}
+	s.certificate = &cert
 	s.certMutex.Unlock()
 
+	// Pick up a renewed CRL together with the certificates
+	if s.config.TCP.TLS.CRLPath != "" && s.tlsConfig.ClientAuth != tls.NoClientCert {
+		caCerts, err := loadCertificates(s.config.TCP.TLS.CAPath)
+		if err != nil {
+			return fmt.Errorf("failed to load client CA: %w", err)
+		}
+		if err := s.loadCRL(caCerts); err != nil {
+			return err
+		}
+	}
+
 	log.Println("✅ TLS certificates reloaded successfully")
 	return nil
 }
 
 // StartCertificateWatcher monitors certificate files for changes
 func (s *Server) StartCertificateWatcher() {
-	if !s.config.TCP.TLS.Enabled {
+	if s.tlsConfig == nil {
 		return
 	}
 
@@ -0,0 +1,62 @@
+package tcp
+
+import (
+	"time"
+
+	"github.com/Arturstriker3/api-go/pkg/protocol"
+)
+
+// Session holds the per-connection state shared between the server and the
+// handler
+type Session struct {
+	RemoteAddr    string
+	TLS           bool
+	Authenticated bool
+
+	// Local is set for Unix socket connections, which never cross the
+	// network
+	Local bool
+
+	// KeyID is the API key the session authenticated with
+	KeyID string
+
+	// CertKeyID is the key mapped to the verified client certificate, if any.
+	// A secret presented later must belong to the same key.
+	CertKeyID string
+
+	// challenge is the outstanding nonce issued by the challenge operation
+	// for challengeKeyID
+	challenge        string
+	challengeKeyID   string
+	challengeExpires time.Time
+
+	// Version is the negotiated protocol version, zero until the first
+	// message has been seen
+	Version      int
+	Capabilities []string
+
+	// Framing is the framing requested during HELLO; the server switches to
+	// it once the HELLO response has been written
+	Framing protocol.Framing
+
+	closing bool
+}
+
+// NewSession creates the state for a freshly accepted connection
+func NewSession(remoteAddr string, isTLS bool) *Session {
+	return &Session{
+		RemoteAddr: remoteAddr,
+		TLS:        isTLS,
+	}
+}
+
+// Close asks the server to drop the connection once the current response
+// has been written
+func (s *Session) Close() {
+	s.closing = true
+}
+
+// Closing reports whether the connection should be dropped
+func (s *Session) Closing() bool {
+	return s.closing
+}
@@ -0,0 +1,95 @@
+package tcp
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/Arturstriker3/api-go/config"
+)
+
+var errPeerCredentialsUnsupported = errors.New("peer credentials are not supported on this platform")
+
+// PeerCredentials identify the process on the other end of a Unix socket
+type PeerCredentials struct {
+	UID uint32
+	GID uint32
+	PID int32
+}
+
+// Identities lists the ways the peer can be mapped in the keys file, most
+// specific first
+func (c *PeerCredentials) Identities() []string {
+	return []string{
+		"uid:" + strconv.FormatUint(uint64(c.UID), 10),
+		"gid:" + strconv.FormatUint(uint64(c.GID), 10),
+	}
+}
+
+// peerAddr stands in for the remote address of a Unix socket connection, which
+// is empty. Limits and bans then apply per uid/gid as they apply per IP.
+type peerAddr struct {
+	cred *PeerCredentials
+}
+
+func (a peerAddr) Network() string {
+	return "unix"
+}
+
+func (a peerAddr) String() string {
+	if a.cred == nil {
+		return "unix"
+	}
+	return fmt.Sprintf("uid=%d,gid=%d", a.cred.UID, a.cred.GID)
+}
+
+// peerConn is a Unix socket connection with the credentials of its peer
+type peerConn struct {
+	net.Conn
+	cred *PeerCredentials
+}
+
+func (c *peerConn) RemoteAddr() net.Addr {
+	return peerAddr{cred: c.cred}
+}
+
+// unixListener reads the peer credentials of every accepted connection
+type unixListener struct {
+	net.Listener
+}
+
+func (l *unixListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	cred, err := readPeerCredentials(conn.(*net.UnixConn))
+	if err != nil && !errors.Is(err, errPeerCredentialsUnsupported) {
+		log.Printf("🟡 Could not read Unix socket peer credentials: %v", err)
+	}
+	return &peerConn{Conn: conn, cred: cred}, nil
+}
+
+// listenUnix opens a Unix socket listener with the configured file mode. A
+// socket left behind by a previous run is removed first.
+func (s *Server) listenUnix(lc config.ListenerConfig) (net.Listener, error) {
+	if info, err := os.Lstat(lc.Address); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(lc.Address)
+	}
+
+	listener, err := net.Listen("unix", lc.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start Unix listener %s: %w", lc, err)
+	}
+	if err := os.Chmod(lc.Address, s.config.TCP.UnixSocketMode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set mode of %s: %w", lc.Address, err)
+	}
+
+	log.Printf("🔌 Unix socket listener on %s (mode %04o)", lc.Address, s.config.TCP.UnixSocketMode)
+	return &unixListener{Listener: listener}, nil
+}
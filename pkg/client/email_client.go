@@ -1,22 +1,56 @@
 package client
 
 import (
+	"crypto/hmac"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net"
+	"strconv"
 	"time"
+
+	"github.com/Arturstriker3/api-go/pkg/protocol"
 )
 
 type EmailClient struct {
-	host       string
-	port       string
-	authSecret string
+	host         string
+	port         string
+	authSecret   string
+	keyID        string
+	tlsConfig    *tls.Config
+	framing      protocol.Framing
+	maxFrameSize int
+	requestSeq   uint64
 }
 
 type EmailRequest struct {
 	To      []string `json:"to"`
+	Cc      []string `json:"cc,omitempty"`
+	Bcc     []string `json:"bcc,omitempty"`
+	ReplyTo string   `json:"reply_to,omitempty"`
 	Subject string   `json:"subject"`
 	Body    string   `json:"body"`
+	// TextBody is the plain-text alternative; leave Body empty to send
+	// plain text only
+	TextBody string `json:"text_body,omitempty"`
+
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// Headers are extra headers such as List-Unsubscribe; headers the
+	// service sets itself (From, To, Subject, Content-*...) are refused
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// IdempotencyKey makes retrying the request safe: the server returns
+	// the original message ID instead of queueing the email again
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// Attachment is a file sent with the email; Content is sent base64-encoded.
+// Set ContentID to embed an image the HTML body shows as "cid:<ContentID>".
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Content     []byte `json:"content"`
+	ContentID   string `json:"content_id,omitempty"`
 }
 
 type Response struct {
@@ -29,60 +63,274 @@ func NewEmailClient(host, port, authSecret string) *EmailClient {
 		host:       host,
 		port:       port,
 		authSecret: authSecret,
+		framing:    protocol.FramingNewline,
 	}
 }
 
+// SetFraming selects how messages are delimited on the wire. The framing is
+// negotiated in the HELLO exchange that opens every connection.
+func (c *EmailClient) SetFraming(framing protocol.Framing) {
+	c.framing = framing
+}
+
+// SetKeyID selects the named API key the secret belongs to. Without it the
+// challenge is answered for the "default" key, and servers without
+// challenge-response auth try the secret against every configured key.
+func (c *EmailClient) SetKeyID(keyID string) {
+	c.keyID = keyID
+}
+
+// SetTLSConfig connects over TLS. Set Certificates on the config to present a
+// client certificate to servers running mutual TLS.
+func (c *EmailClient) SetTLSConfig(tlsConfig *tls.Config) {
+	c.tlsConfig = tlsConfig
+}
+
+// SetMaxFrameSize limits the size of the responses the client accepts
+func (c *EmailClient) SetMaxFrameSize(size int) {
+	c.maxFrameSize = size
+}
+
 func (c *EmailClient) SendEmail(request *EmailRequest) error {
-	// Conectar ao servidor
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%s", c.host, c.port), 5*time.Second)
+	_, err := c.Send(request)
+	return err
+}
+
+// Send queues an email and returns the message ID assigned by the service,
+// which can be passed to Status or Cancel
+func (c *EmailClient) Send(request *EmailRequest) (*protocol.SendResult, error) {
+	conn, framer, _, err := c.connect()
 	if err != nil {
-		return fmt.Errorf("failed to connect to email service: %w", err)
+		return nil, err
 	}
 	defer conn.Close()
 
-	// Enviar autenticação
-	auth := struct {
-		Secret string `json:"secret"`
+	// Enviar requisição de email
+	var result protocol.SendResult
+	if err := c.roundTrip(framer, protocol.OpSend, request, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Status looks up the delivery state of a previously queued message
+func (c *EmailClient) Status(messageID string) (*protocol.StatusResult, error) {
+	return c.statusRequest(protocol.OpStatus, messageID)
+}
+
+// Cancel stops a message that is still waiting in the queue from being sent
+func (c *EmailClient) Cancel(messageID string) (*protocol.StatusResult, error) {
+	return c.statusRequest(protocol.OpCancel, messageID)
+}
+
+func (c *EmailClient) statusRequest(op, messageID string) (*protocol.StatusResult, error) {
+	conn, framer, _, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var result protocol.StatusResult
+	if err := c.roundTrip(framer, op, protocol.StatusPayload{MessageID: messageID}, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ListBans returns the client addresses currently banned for repeated
+// authentication failures. The key needs the admin scope.
+func (c *EmailClient) ListBans() ([]protocol.Ban, error) {
+	conn, framer, _, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var result protocol.ListBansResult
+	if err := c.roundTrip(framer, protocol.OpListBans, struct{}{}, &result); err != nil {
+		return nil, err
+	}
+	return result.Bans, nil
+}
+
+// ClearBans lifts the ban on ip, or on every address when ip is empty, and
+// returns how many bans were lifted. The key needs the admin scope.
+func (c *EmailClient) ClearBans(ip string) (int, error) {
+	conn, framer, _, err := c.connect()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var result protocol.ClearBansResult
+	if err := c.roundTrip(framer, protocol.OpClearBans, protocol.ClearBansPayload{IP: ip}, &result); err != nil {
+		return 0, err
+	}
+	return result.Cleared, nil
+}
+
+// SendBatch queues several emails in one round trip. Items are accepted or
+// rejected individually; inspect the returned items to find failures.
+func (c *EmailClient) SendBatch(requests []*EmailRequest) (*protocol.BatchResult, error) {
+	conn, framer, hello, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if !protocol.HasCapability(hello.Capabilities, protocol.CapBatch) {
+		return nil, fmt.Errorf("email service does not support batch sends")
+	}
+
+	payload := struct {
+		Items []*EmailRequest `json:"items"`
 	}{
-		Secret: c.authSecret,
+		Items: requests,
+	}
+
+	var result protocol.BatchResult
+	if err := c.roundTrip(framer, protocol.OpBatch, payload, &result); err != nil {
+		return nil, err
 	}
+	return &result, nil
+}
+
+// connect dials the server, negotiates the protocol and authenticates
+func (c *EmailClient) connect() (net.Conn, *protocol.Framer, *protocol.HelloResult, error) {
+	// Conectar ao servidor
+	address := net.JoinHostPort(c.host, c.port)
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
 
-	authBytes, err := json.Marshal(auth)
+	var conn net.Conn
+	var err error
+	if c.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, c.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to marshal auth data: %w", err)
+		return nil, nil, nil, fmt.Errorf("failed to connect to email service: %w", err)
 	}
 
-	_, err = conn.Write(authBytes)
+	framer, hello, err := c.hello(conn)
 	if err != nil {
-		return fmt.Errorf("failed to send auth data: %w", err)
+		conn.Close()
+		return nil, nil, nil, err
 	}
 
-	// Enviar requisição de email
-	requestBytes, err := json.Marshal(request)
+	if err := c.authenticate(framer, hello); err != nil {
+		conn.Close()
+		return nil, nil, nil, fmt.Errorf("authentication failed: %w", err)
+	}
+
+	return conn, framer, hello, nil
+}
+
+// authenticate proves knowledge of the secret. When the server supports
+// challenge-response auth only a SCRAM-SHA-256 proof over a server nonce is
+// sent, so the secret never crosses the wire, and the server's signature is
+// checked in return.
+func (c *EmailClient) authenticate(framer *protocol.Framer, hello *protocol.HelloResult) error {
+	// A client certificate may be the only credential on mutual TLS
+	if c.authSecret == "" && c.tlsConfig != nil && len(c.tlsConfig.Certificates) > 0 {
+		return nil
+	}
+
+	if !protocol.HasCapability(hello.Capabilities, protocol.CapAuthSCRAM) {
+		auth := protocol.AuthPayload{KeyID: c.keyID, Secret: c.authSecret}
+		return c.roundTrip(framer, protocol.OpAuth, auth, nil)
+	}
+
+	var challenge protocol.ChallengeResult
+	if err := c.roundTrip(framer, protocol.OpChallenge, protocol.ChallengePayload{KeyID: c.keyID}, &challenge); err != nil {
+		return err
+	}
+	proof, serverSignature, err := protocol.AnswerChallenge(c.authSecret, c.challengeKeyID(), challenge)
+	if err != nil {
+		return err
+	}
+
+	auth := protocol.AuthPayload{KeyID: c.keyID, Nonce: challenge.Nonce, Proof: proof}
+	var result protocol.AuthResult
+	if err := c.roundTrip(framer, protocol.OpAuth, auth, &result); err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(result.ServerSignature), []byte(serverSignature)) {
+		return fmt.Errorf("server signature does not match, the server does not know this key")
+	}
+	return nil
+}
+
+// challengeKeyID is the key a challenge is answered for; without a key ID
+// the server challenges the "default" key built from TCP_AUTH_SECRET
+func (c *EmailClient) challengeKeyID() string {
+	if c.keyID == "" {
+		return "default"
+	}
+	return c.keyID
+}
+
+// hello opens a versioned session and switches to the configured framing.
+// The HELLO itself is always sent newline-delimited.
+func (c *EmailClient) hello(conn net.Conn) (*protocol.Framer, *protocol.HelloResult, error) {
+	framer := protocol.NewFramer(conn, protocol.FramingNewline, c.maxFrameSize)
+
+	payload := protocol.HelloPayload{
+		Version:      protocol.CurrentVersion,
+		Capabilities: []string{"framing:" + string(c.framing), protocol.CapBatch, protocol.CapAuthSCRAM},
+		Framing:      c.framing,
+		Client:       "gomailer-go-client",
+	}
+
+	var result protocol.HelloResult
+	if err := c.roundTrip(framer, protocol.OpHello, payload, &result); err != nil {
+		return nil, nil, fmt.Errorf("protocol negotiation failed: %w", err)
+	}
+	if result.Version < protocol.Version2 {
+		return nil, nil, fmt.Errorf("server negotiated unsupported protocol version %d", result.Version)
+	}
+
+	framer.SetFraming(c.framing)
+	return framer, &result, nil
+}
+
+// roundTrip sends one envelope request and decodes the matching response
+// into result. Service errors are returned as *protocol.Error so callers can
+// inspect the error code.
+func (c *EmailClient) roundTrip(framer *protocol.Framer, op string, payload, result interface{}) error {
+	c.requestSeq++
+	request, err := protocol.NewRequest(op, strconv.FormatUint(c.requestSeq, 10), payload)
 	if err != nil {
-		return fmt.Errorf("failed to marshal email request: %w", err)
+		return fmt.Errorf("failed to marshal %s request: %w", op, err)
 	}
 
-	_, err = conn.Write(requestBytes)
+	requestBytes, err := json.Marshal(request)
 	if err != nil {
-		return fmt.Errorf("failed to send email request: %w", err)
+		return fmt.Errorf("failed to marshal %s request: %w", op, err)
+	}
+
+	if err := framer.WriteFrame(requestBytes); err != nil {
+		return fmt.Errorf("failed to send %s request: %w", op, err)
 	}
 
 	// Ler resposta
-	buffer := make([]byte, 1024)
-	n, err := conn.Read(buffer)
+	responseBytes, err := framer.ReadFrame()
 	if err != nil {
 		return fmt.Errorf("failed to read response: %w", err)
 	}
 
-	var response Response
-	if err := json.Unmarshal(buffer[:n], &response); err != nil {
+	var response protocol.Response
+	if err := json.Unmarshal(responseBytes, &response); err != nil {
 		return fmt.Errorf("failed to parse response: %w", err)
 	}
 
-	if response.Error != "" {
-		return fmt.Errorf("email service error: %s", response.Error)
+	if response.RequestID != "" && response.RequestID != request.RequestID {
+		return fmt.Errorf("unexpected response for request %s", response.RequestID)
+	}
+
+	if err := response.DecodeResult(result); err != nil {
+		return fmt.Errorf("email service error: %w", err)
 	}
 
 	return nil
-} 
\ No newline at end of file
+}
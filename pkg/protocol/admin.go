@@ -0,0 +1,33 @@
+package protocol
+
+import "time"
+
+// Administrative operations, available to keys with the admin scope
+const (
+	OpListBans  = "list_bans"
+	OpClearBans = "clear_bans"
+)
+
+// Ban is one client address currently locked out after repeated
+// authentication failures
+type Ban struct {
+	IP       string    `json:"ip"`
+	Until    time.Time `json:"until"`
+	Bans     int       `json:"bans"`
+	Failures int       `json:"failures"`
+}
+
+// ListBansResult lists the active bans
+type ListBansResult struct {
+	Bans []Ban `json:"bans"`
+}
+
+// ClearBansPayload names the address to unban; an empty IP clears every ban
+type ClearBansPayload struct {
+	IP string `json:"ip,omitempty"`
+}
+
+// ClearBansResult reports how many bans were lifted
+type ClearBansResult struct {
+	Cleared int `json:"cleared"`
+}
@@ -0,0 +1,106 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+// OpChallenge asks the server for a nonce to answer instead of sending the
+// secret itself
+const OpChallenge = "challenge"
+
+// CapAuthSCRAM is announced by servers that support challenge-response auth
+const CapAuthSCRAM = "auth:scram-sha-256"
+
+// AlgorithmSCRAMSHA256 is the only supported challenge algorithm
+const AlgorithmSCRAMSHA256 = "scram-sha-256"
+
+// MinSCRAMIterations is the lowest PBKDF2 iteration count accepted for a key
+const MinSCRAMIterations = 4096
+
+// ChallengePayload names the key that will answer the challenge. Without it
+// the challenge is for the "default" key built from TCP_AUTH_SECRET.
+type ChallengePayload struct {
+	KeyID string `json:"key_id,omitempty"`
+}
+
+// ChallengeResult carries the single-use nonce and the key's salt and
+// iteration count the client needs to compute its proof
+type ChallengeResult struct {
+	Nonce      string `json:"nonce"`
+	Algorithm  string `json:"algorithm"`
+	Salt       string `json:"salt"`
+	Iterations int    `json:"iterations"`
+	ExpiresIn  int    `json:"expires_in"`
+}
+
+// SCRAMKeys derives the SCRAM-SHA-256 keys of a secret (RFC 5802):
+//
+//	SaltedPassword = PBKDF2-HMAC-SHA256(secret, salt, iterations)
+//	ClientKey      = HMAC(SaltedPassword, "Client Key")
+//	StoredKey      = SHA-256(ClientKey)
+//	ServerKey      = HMAC(SaltedPassword, "Server Key")
+//
+// The server only stores StoredKey and ServerKey, from which a proof cannot
+// be computed.
+func SCRAMKeys(secret string, salt []byte, iterations int) (clientKey, storedKey, serverKey []byte, err error) {
+	salted, err := pbkdf2.Key(sha256.New, secret, salt, iterations, sha256.Size)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to derive salted password: %w", err)
+	}
+	clientKey = computeHMAC(salted, "Client Key")
+	stored := sha256.Sum256(clientKey)
+	return clientKey, stored[:], computeHMAC(salted, "Server Key"), nil
+}
+
+// SCRAMAuthMessage is what both proofs sign: the key ID and the nonce, so a
+// proof cannot be replayed for another key or challenge
+func SCRAMAuthMessage(keyID, nonce string) string {
+	return keyID + "," + nonce
+}
+
+// SCRAMClientSignature computes HMAC(StoredKey, AuthMessage)
+func SCRAMClientSignature(storedKey []byte, keyID, nonce string) []byte {
+	return computeHMAC(storedKey, SCRAMAuthMessage(keyID, nonce))
+}
+
+// SCRAMServerSignature computes base64(HMAC(ServerKey, AuthMessage)), which
+// the server returns so the client can check it also knows the key
+func SCRAMServerSignature(serverKey []byte, keyID, nonce string) string {
+	return base64.StdEncoding.EncodeToString(computeHMAC(serverKey, SCRAMAuthMessage(keyID, nonce)))
+}
+
+// AnswerChallenge computes the proof for an auth request answering a
+// challenge, base64(ClientKey XOR HMAC(StoredKey, AuthMessage)), and the
+// server signature the client should expect in return
+func AnswerChallenge(secret, keyID string, challenge ChallengeResult) (proof, serverSignature string, err error) {
+	if challenge.Algorithm != AlgorithmSCRAMSHA256 {
+		return "", "", fmt.Errorf("unsupported challenge algorithm %q", challenge.Algorithm)
+	}
+	if challenge.Iterations < MinSCRAMIterations {
+		return "", "", fmt.Errorf("challenge iteration count %d is below %d", challenge.Iterations, MinSCRAMIterations)
+	}
+	salt, err := base64.StdEncoding.DecodeString(challenge.Salt)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid challenge salt: %w", err)
+	}
+
+	clientKey, storedKey, serverKey, err := SCRAMKeys(secret, salt, challenge.Iterations)
+	if err != nil {
+		return "", "", err
+	}
+	signature := SCRAMClientSignature(storedKey, keyID, challenge.Nonce)
+	for i := range clientKey {
+		clientKey[i] ^= signature[i]
+	}
+	return base64.StdEncoding.EncodeToString(clientKey), SCRAMServerSignature(serverKey, keyID, challenge.Nonce), nil
+}
+
+func computeHMAC(key []byte, message string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
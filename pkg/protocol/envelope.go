@@ -0,0 +1,225 @@
+package protocol
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Operations understood by the envelope protocol
+const (
+	OpAuth   = "auth"
+	OpSend   = "send"
+	OpPing   = "ping"
+	OpStatus = "status"
+	OpCancel = "cancel"
+	OpBatch  = "batch"
+)
+
+// Machine-readable error codes returned in Response.Error
+const (
+	CodeInvalidJSON    = "invalid_json"
+	CodeInvalidRequest = "invalid_request"
+	CodeUnknownOp      = "unknown_op"
+	CodeUnsupportedOp  = "unsupported_op"
+	CodeAuthRequired   = "auth_required"
+	CodeAuthFailed     = "auth_failed"
+	CodeForbidden      = "forbidden"
+	CodeHMACRequired   = "hmac_required"
+	CodeInvalidPayload = "invalid_payload"
+	CodeInvalidEmail   = "invalid_email"
+	CodeQueueFailed    = "queue_failed"
+	CodeFrameTooLarge  = "frame_too_large"
+	CodeBatchTooLarge  = "batch_too_large"
+	CodeNotFound       = "not_found"
+	CodeNotCancellable = "not_cancellable"
+	CodeInternal       = "internal_error"
+
+	// CodeIdempotencyConflict is returned when an idempotency key is reused
+	// with a different email
+	CodeIdempotencyConflict = "idempotency_conflict"
+	// CodeBusy is returned while the queue backlog is too deep; the error
+	// carries the number of seconds to wait in RetryAfter
+	CodeBusy = "busy"
+	// CodeAttachmentTooLarge is returned when an attachment, or all of them
+	// together, exceed the configured size limits
+	CodeAttachmentTooLarge = "attachment_too_large"
+)
+
+// Request is the envelope every typed message is wrapped in
+type Request struct {
+	Op        string          `json:"op"`
+	RequestID string          `json:"request_id,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+}
+
+// Response echoes the request ID and carries either a result or an error
+type Response struct {
+	RequestID string          `json:"request_id,omitempty"`
+	Op        string          `json:"op,omitempty"`
+	OK        bool            `json:"ok"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     *Error          `json:"error,omitempty"`
+}
+
+// Error describes why a request failed. RetryAfter, in seconds, is set when
+// the request may succeed if repeated later.
+type Error struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// AuthPayload is the payload of an auth request. Either Secret or, after a
+// challenge, Nonce and Proof must be set.
+type AuthPayload struct {
+	KeyID  string `json:"key_id,omitempty"`
+	Secret string `json:"secret,omitempty"`
+	Nonce  string `json:"nonce,omitempty"`
+	Proof  string `json:"proof,omitempty"`
+}
+
+// AuthResult is returned after a successful auth request. ServerSignature is
+// set when answering a challenge and proves the server holds the key too.
+type AuthResult struct {
+	Authenticated   bool     `json:"authenticated"`
+	KeyID           string   `json:"key_id"`
+	Scopes          []string `json:"scopes"`
+	ServerSignature string   `json:"server_signature,omitempty"`
+}
+
+// PingResult is returned by the ping operation
+type PingResult struct {
+	Pong       bool  `json:"pong"`
+	ServerTime int64 `json:"server_time"`
+}
+
+// SendResult is returned after an email has been accepted. Replayed is set
+// when an earlier request with the same idempotency key queued the email.
+type SendResult struct {
+	MessageID string `json:"message_id"`
+	Status    string `json:"status"`
+	Replayed  bool   `json:"replayed,omitempty"`
+}
+
+// StatusPayload identifies the message for the status and cancel operations
+type StatusPayload struct {
+	MessageID string `json:"message_id"`
+}
+
+// StatusEvent is one recorded state transition of a message
+type StatusEvent struct {
+	State  string    `json:"state"`
+	Reason string    `json:"reason,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// StatusResult describes the delivery state of a message. State is one of
+// queued, sending, sent, failed or cancelled.
+type StatusResult struct {
+	MessageID string        `json:"message_id"`
+	State     string        `json:"state"`
+	Reason    string        `json:"reason,omitempty"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	History   []StatusEvent `json:"history"`
+}
+
+// Per-item states reported in a BatchResult
+const (
+	ItemAccepted = "accepted"
+	ItemRejected = "rejected"
+)
+
+// BatchPayload carries several emails in one request. Items are kept raw so
+// a malformed item only rejects that item.
+type BatchPayload struct {
+	Items []json.RawMessage `json:"items"`
+}
+
+// BatchItemResult reports what happened to one item of a batch
+type BatchItemResult struct {
+	Index     int    `json:"index"`
+	Status    string `json:"status"`
+	MessageID string `json:"message_id,omitempty"`
+	Replayed  bool   `json:"replayed,omitempty"`
+	Code      string `json:"code,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// BatchResult is returned by the batch operation
+type BatchResult struct {
+	Accepted int               `json:"accepted"`
+	Rejected int               `json:"rejected"`
+	Items    []BatchItemResult `json:"items"`
+}
+
+// ParseRequest decodes a frame as an envelope request. The second return
+// value is false when the frame is not an envelope (no "op" field), in which
+// case the caller should fall back to the legacy message shapes.
+func ParseRequest(frame []byte) (*Request, bool) {
+	var req Request
+	if err := json.Unmarshal(frame, &req); err != nil || req.Op == "" {
+		return nil, false
+	}
+	return &req, true
+}
+
+// NewRequest builds a request envelope with the given payload
+func NewRequest(op, requestID string, payload interface{}) (*Request, error) {
+	req := &Request{Op: op, RequestID: requestID}
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		req.Payload = raw
+	}
+	return req, nil
+}
+
+// NewResult builds a successful response for the request
+func NewResult(req *Request, result interface{}) *Response {
+	resp := &Response{OK: true}
+	if req != nil {
+		resp.RequestID = req.RequestID
+		resp.Op = req.Op
+	}
+	if result != nil {
+		raw, err := json.Marshal(result)
+		if err != nil {
+			return NewError(req, CodeInternal, "failed to encode result")
+		}
+		resp.Result = raw
+	}
+	return resp
+}
+
+// NewError builds a failed response for the request
+func NewError(req *Request, code, message string) *Response {
+	resp := &Response{
+		Error: &Error{Code: code, Message: message},
+	}
+	if req != nil {
+		resp.RequestID = req.RequestID
+		resp.Op = req.Op
+	}
+	return resp
+}
+
+// DecodeResult unmarshals the response result into v, or returns the
+// response error when the request failed
+func (r *Response) DecodeResult(v interface{}) error {
+	if !r.OK {
+		if r.Error != nil {
+			return r.Error
+		}
+		return &Error{Code: CodeInternal, Message: "request failed without error details"}
+	}
+	if v == nil || len(r.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(r.Result, v)
+}
@@ -0,0 +1,249 @@
+package protocol
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Framing identifies how messages are delimited on a connection
+type Framing string
+
+const (
+	// FramingNewline delimits messages with '\n'. A frame also ends when a
+	// complete top-level JSON value has been read, so legacy clients that
+	// never send a newline keep working.
+	FramingNewline Framing = "newline"
+
+	// FramingLength prefixes every message with its size as a 4-byte
+	// big-endian unsigned integer.
+	FramingLength Framing = "length"
+)
+
+// DefaultMaxFrameSize is used when no explicit limit is configured
+const DefaultMaxFrameSize = 10 * 1024 * 1024
+
+// lengthPrefixSize is the size of the FramingLength header
+const lengthPrefixSize = 4
+
+// ErrFrameTooLarge is returned when a frame exceeds the configured maximum.
+// The stream cannot be resynchronised afterwards, so the connection should be
+// closed once the error has been reported.
+var ErrFrameTooLarge = errors.New("frame exceeds maximum size")
+
+// ParseFraming converts a framing name into a Framing value
+func ParseFraming(name string) (Framing, error) {
+	switch Framing(name) {
+	case FramingNewline:
+		return FramingNewline, nil
+	case FramingLength:
+		return FramingLength, nil
+	default:
+		return "", fmt.Errorf("unknown framing %q", name)
+	}
+}
+
+// Framer reads and writes whole messages over a stream connection
+type Framer struct {
+	reader       *bufio.Reader
+	writer       io.Writer
+	framing      Framing
+	maxFrameSize int
+
+	// pendingNewline is set when the last newline frame ended on a closing
+	// bracket, so its terminating newline may still be in the stream
+	pendingNewline bool
+}
+
+// NewFramer creates a framer for the given stream. A maxFrameSize of zero or
+// less falls back to DefaultMaxFrameSize.
+func NewFramer(rw io.ReadWriter, framing Framing, maxFrameSize int) *Framer {
+	if maxFrameSize <= 0 {
+		maxFrameSize = DefaultMaxFrameSize
+	}
+	return &Framer{
+		reader:       bufio.NewReader(rw),
+		writer:       rw,
+		framing:      framing,
+		maxFrameSize: maxFrameSize,
+	}
+}
+
+// DetectFraming peeks at the first byte sent by the client and selects the
+// framing accordingly: JSON text always starts with a printable character
+// while a length prefix starts with a zero byte for frames under 16 MiB, so
+// servers relying on detection must keep their frame limit below that.
+func (f *Framer) DetectFraming() (Framing, error) {
+	first, err := f.reader.Peek(1)
+	if err != nil {
+		return "", err
+	}
+	if first[0] == 0 {
+		f.framing = FramingLength
+	} else {
+		f.framing = FramingNewline
+	}
+	return f.framing, nil
+}
+
+// Framing returns the framing currently in use
+func (f *Framer) Framing() Framing {
+	return f.framing
+}
+
+// SetFraming switches the framing used for subsequent reads and writes
+func (f *Framer) SetFraming(framing Framing) {
+	f.framing = framing
+}
+
+// SetMaxFrameSize changes the largest frame accepted from the next read on.
+// Like in NewFramer, zero or less falls back to DefaultMaxFrameSize.
+func (f *Framer) SetMaxFrameSize(maxFrameSize int) {
+	if maxFrameSize <= 0 {
+		maxFrameSize = DefaultMaxFrameSize
+	}
+	f.maxFrameSize = maxFrameSize
+}
+
+// MaxFrameSize returns the largest frame the framer accepts
+func (f *Framer) MaxFrameSize() int {
+	return f.maxFrameSize
+}
+
+// ReadFrame reads the next complete message from the stream
+func (f *Framer) ReadFrame() ([]byte, error) {
+	if f.framing == FramingLength {
+		return f.readLengthFrame()
+	}
+	return f.readNewlineFrame()
+}
+
+// WriteFrame writes one complete message to the stream
+func (f *Framer) WriteFrame(payload []byte) error {
+	if len(payload) > f.maxFrameSize {
+		return ErrFrameTooLarge
+	}
+
+	var frame []byte
+	if f.framing == FramingLength {
+		frame = make([]byte, lengthPrefixSize, lengthPrefixSize+len(payload))
+		binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+		frame = append(frame, payload...)
+	} else {
+		frame = make([]byte, 0, len(payload)+1)
+		frame = append(frame, payload...)
+		frame = append(frame, '\n')
+	}
+
+	_, err := f.writer.Write(frame)
+	return err
+}
+
+func (f *Framer) readLengthFrame() ([]byte, error) {
+	if f.pendingNewline {
+		f.pendingNewline = false
+		if err := f.skipNewline(); err != nil {
+			return nil, err
+		}
+	}
+
+	var header [lengthPrefixSize]byte
+	if _, err := io.ReadFull(f.reader, header[:]); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[:])
+	if uint64(size) > uint64(f.maxFrameSize) {
+		return nil, ErrFrameTooLarge
+	}
+
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(f.reader, frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return frame, nil
+}
+
+// readNewlineFrame reads until a newline outside of a JSON string or until a
+// top-level JSON object or array is closed, whichever comes first. Blank
+// lines between frames are skipped.
+func (f *Framer) readNewlineFrame() ([]byte, error) {
+	var (
+		frame    []byte
+		depth    int
+		inString bool
+		escaped  bool
+	)
+	f.pendingNewline = false
+
+	for {
+		b, err := f.reader.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(frame) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		if len(frame) == 0 && isFrameSpace(b) {
+			continue
+		}
+		if b == '\n' && !inString {
+			return trimFrame(frame), nil
+		}
+
+		if len(frame) >= f.maxFrameSize {
+			return nil, ErrFrameTooLarge
+		}
+		frame = append(frame, b)
+
+		switch {
+		case inString && escaped:
+			escaped = false
+		case inString && b == '\\':
+			escaped = true
+		case b == '"':
+			inString = !inString
+		case inString:
+		case b == '{' || b == '[':
+			depth++
+		case b == '}' || b == ']':
+			depth--
+			if depth <= 0 {
+				f.pendingNewline = true
+				return frame, nil
+			}
+		}
+	}
+}
+
+// skipNewline drops the "\n" or "\r\n" left over from a newline frame
+// after switching to length-prefixed framing
+func (f *Framer) skipNewline() error {
+	for _, expected := range []byte{'\r', '\n'} {
+		next, err := f.reader.Peek(1)
+		if err != nil {
+			return err
+		}
+		if next[0] == expected {
+			f.reader.Discard(1)
+		}
+	}
+	return nil
+}
+
+func isFrameSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\r' || b == '\n'
+}
+
+func trimFrame(frame []byte) []byte {
+	for len(frame) > 0 && isFrameSpace(frame[len(frame)-1]) {
+		frame = frame[:len(frame)-1]
+	}
+	return frame
+}
@@ -0,0 +1,61 @@
+package protocol
+
+// OpHello negotiates the protocol version and capabilities. It must be the
+// first request on a connection.
+const OpHello = "hello"
+
+// Protocol versions
+const (
+	// Version1 is the legacy protocol: {"secret": "..."} followed by bare
+	// EmailData objects with {"message"}/{"error"} responses.
+	Version1 = 1
+
+	// Version2 wraps every request and response in the typed envelope.
+	Version2 = 2
+
+	// CurrentVersion is the newest version this package speaks
+	CurrentVersion = Version2
+)
+
+// Capabilities that can be announced in a HELLO exchange
+const (
+	CapFramingNewline = "framing:" + string(FramingNewline)
+	CapFramingLength  = "framing:" + string(FramingLength)
+	CapCompression    = "compression"
+	CapBatch          = "batch"
+)
+
+// Error codes specific to the HELLO exchange
+const (
+	CodeUnsupportedVersion = "unsupported_version"
+	CodeUnexpectedHello    = "unexpected_hello"
+	CodeUnsupportedFraming = "unsupported_framing"
+)
+
+// HelloPayload is sent by the client to open a versioned session
+type HelloPayload struct {
+	Version      int      `json:"version"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	Framing      Framing  `json:"framing,omitempty"`
+	Client       string   `json:"client,omitempty"`
+}
+
+// HelloResult is the server's answer to a HELLO request. Framing is the
+// framing both sides switch to once the response has been written.
+type HelloResult struct {
+	Version      int      `json:"version"`
+	MinVersion   int      `json:"min_version"`
+	MaxVersion   int      `json:"max_version"`
+	Capabilities []string `json:"capabilities"`
+	Framing      Framing  `json:"framing"`
+}
+
+// HasCapability reports whether name is present in capabilities
+func HasCapability(capabilities []string, name string) bool {
+	for _, capability := range capabilities {
+		if capability == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+//go:build generate_api_key
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Arturstriker3/api-go/internal/auth"
+)
+
+// Generates a random API key secret and prints the entry to add to the keys
+// file referenced by AUTH_KEYS_FILE.
+//
+// Usage: go run -tags generate_api_key scripts/generate-api-key.go <key-id> [scope,scope...]
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println("Usage: go run -tags generate_api_key scripts/generate-api-key.go <key-id> [send,status,admin]")
+		os.Exit(1)
+	}
+
+	keyID := os.Args[1]
+	scopes := []string{"send", "status"}
+	if len(os.Args) > 2 {
+		scopes = strings.Split(os.Args[2], ",")
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		fmt.Printf("🔴 Failed to generate secret: %v\n", err)
+		os.Exit(1)
+	}
+	secret := base64.RawURLEncoding.EncodeToString(buf)
+	verifier, err := auth.NewVerifier(secret)
+	if err != nil {
+		fmt.Printf("🔴 Failed to derive verifier: %v\n", err)
+		os.Exit(1)
+	}
+
+	entry := map[string]interface{}{
+		"id":          keyID,
+		"secret_hash": verifier,
+		"enabled":     true,
+		"scopes":      scopes,
+	}
+	entryJSON, _ := json.MarshalIndent(entry, "", "  ")
+
+	// Only a salted SCRAM verifier is stored: it can check the secret or a
+	// challenge proof, but cannot be turned back into either
+	fmt.Printf("🔑 Secret for %q (give this to the client; the keys file only keeps a salted verifier of it):\n%s\n\n", keyID, secret)
+	fmt.Printf("📄 Add this entry to the \"keys\" array of your keys file:\n%s\n", entryJSON)
+}
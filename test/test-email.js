@@ -3,6 +3,7 @@ const tls = require("tls");
 const fs = require("fs").promises;
 const path = require("path");
 const readline = require("readline");
+const crypto = require("crypto");
 
 class EmailTestClient {
   constructor() {
@@ -10,6 +11,7 @@ class EmailTestClient {
     this.emailTemplate = null;
     this.client = null;
     this.authenticated = false;
+    this.requestSeq = 0;
     this.rl = readline.createInterface({
       input: process.stdin,
       output: process.stdout,
@@ -144,57 +146,116 @@ class EmailTestClient {
     });
   }
 
-  authenticate() {
+  // Sends one envelope request and resolves with the matching response.
+  // Responses are newline-delimited, so partial chunks are buffered.
+  request(op, payload, timeoutMs = 10000) {
     return new Promise((resolve, reject) => {
-      if (this.authenticated) {
-        resolve();
-        return;
-      }
+      const requestId = String(++this.requestSeq);
+      let buffer = "";
 
-      const authData = {
-        secret: this.config.connection.authSecret,
+      const cleanup = () => {
+        clearTimeout(timeoutHandler);
+        this.client.removeListener("data", dataHandler);
       };
 
-      const authType = this.config.connection.tlsEnabled
-        ? "🔑 encrypted"
-        : "⚠️  plain text";
-      console.log(`Sending authentication (${authType})...`);
+      const dataHandler = (data) => {
+        buffer += data.toString();
+        let newline;
+        while ((newline = buffer.indexOf("\n")) >= 0) {
+          const line = buffer.slice(0, newline).trim();
+          buffer = buffer.slice(newline + 1);
+          if (!line) continue;
 
-      const responseHandler = (data) => {
-        const response = data.toString().trim();
-        console.log("✅ Auth response received:", response);
-        this.client.removeListener("data", responseHandler);
+          let parsed;
+          try {
+            parsed = JSON.parse(line);
+          } catch (e) {
+            cleanup();
+            reject(new Error("Invalid response format"));
+            return;
+          }
+          if (parsed.request_id && parsed.request_id !== requestId) continue;
 
-        try {
-          const parsed = JSON.parse(response);
-          if (parsed.error) {
-            reject(new Error(parsed.error));
+          cleanup();
+          if (parsed.ok) {
+            resolve(parsed.result || {});
           } else {
-            this.authenticated = true;
-            const securityStatus = this.config.connection.tlsEnabled
-              ? "🎉 secured with TLS!"
-              : "⚠️  but connection is not encrypted!";
-            console.log(`Authentication successful - ${securityStatus}`);
-            resolve();
+            const error = parsed.error || {};
+            reject(new Error(`${error.code}: ${error.message}`));
           }
-        } catch (e) {
-          console.error("❌ Failed to parse auth response:", e.message);
-          reject(new Error("Invalid response format"));
+          return;
         }
       };
 
       const timeoutHandler = setTimeout(() => {
-        this.client.removeListener("data", responseHandler);
-        reject(new Error("Authentication timeout"));
-      }, 5000);
+        this.client.removeListener("data", dataHandler);
+        reject(new Error(`${op} timeout`));
+      }, timeoutMs);
+
+      this.client.on("data", dataHandler);
+      this.client.write(
+        JSON.stringify({ op, request_id: requestId, payload }) + "\n"
+      );
+    });
+  }
 
-      this.client.on("data", (data) => {
-        clearTimeout(timeoutHandler);
-        responseHandler(data);
-      });
+  async authenticate() {
+    if (this.authenticated) {
+      return;
+    }
 
-      this.client.write(JSON.stringify(authData) + "\n");
-    });
+    const { authSecret, keyId } = this.config.connection;
+    // "hmac" is the old name of the challenge-response method
+    const authMethod = this.config.connection.authMethod || "scram";
+    const challengeAuth = authMethod === "scram" || authMethod === "hmac";
+
+    if (challengeAuth) {
+      // SCRAM-SHA-256 challenge-response: only a proof over the server nonce
+      // is sent, so the secret never crosses the wire, and the server proves
+      // it knows the key too
+      console.log("🔑 Requesting authentication challenge (SCRAM-SHA-256)...");
+      const challengeKeyId = keyId || "default";
+      const challenge = await this.request("challenge", { key_id: challengeKeyId });
+      const hmac = (key, data) =>
+        crypto.createHmac("sha256", key).update(data).digest();
+      const message = `${challengeKeyId},${challenge.nonce}`;
+      const salted = crypto.pbkdf2Sync(
+        authSecret,
+        Buffer.from(challenge.salt, "base64"),
+        challenge.iterations,
+        32,
+        "sha256"
+      );
+      const clientKey = hmac(salted, "Client Key");
+      const storedKey = crypto.createHash("sha256").update(clientKey).digest();
+      const clientSignature = hmac(storedKey, message);
+      const proof = Buffer.from(
+        clientKey.map((byte, i) => byte ^ clientSignature[i])
+      ).toString("base64");
+
+      const result = await this.request(
+        "auth",
+        { key_id: challengeKeyId, nonce: challenge.nonce, proof },
+        5000
+      );
+      const serverSignature = hmac(hmac(salted, "Server Key"), message).toString("base64");
+      if (result.server_signature !== serverSignature) {
+        throw new Error("Server signature does not match");
+      }
+    } else {
+      const authType = this.config.connection.tlsEnabled
+        ? "🔑 encrypted"
+        : "⚠️  plain text";
+      console.log(`Sending authentication (${authType})...`);
+      await this.request("auth", { key_id: keyId, secret: authSecret }, 5000);
+    }
+
+    this.authenticated = true;
+    const securityStatus =
+      this.config.connection.tlsEnabled || challengeAuth
+        ? "🎉 secret was never sent in clear text!"
+        : "⚠️  but connection is not encrypted!";
+    console.log(`Authentication successful - ${securityStatus}`);
   }
 
   async sendEmail(count = 1) {
@@ -249,41 +310,11 @@ class EmailTestClient {
     return results;
   }
 
-  sendSingleEmail(emailData) {
-    return new Promise((resolve, reject) => {
-      const data = JSON.stringify(emailData) + "\n";
-      console.log("Sending email data:", data.trim());
-
-      const responseHandler = (data) => {
-        const response = data.toString().trim();
-        console.log("Email response received:", response);
-        this.client.removeListener("data", responseHandler);
-
-        try {
-          const parsed = JSON.parse(response);
-          if (parsed.error) {
-            reject(new Error(parsed.error));
-          } else {
-            resolve(parsed.message || "Success");
-          }
-        } catch (e) {
-          console.error("Failed to parse email response:", e.message);
-          reject(new Error("Invalid response format"));
-        }
-      };
-
-      const timeoutHandler = setTimeout(() => {
-        this.client.removeListener("data", responseHandler);
-        reject(new Error("Email sending timeout"));
-      }, 10000);
-
-      this.client.on("data", (data) => {
-        clearTimeout(timeoutHandler);
-        responseHandler(data);
-      });
-
-      this.client.write(data);
-    });
+  async sendSingleEmail(emailData) {
+    console.log("Sending email data:", JSON.stringify(emailData));
+    const result = await this.request("send", emailData);
+    console.log("Email response received:", result);
+    return result.message_id || "Success";
   }
 
   async disconnect() {